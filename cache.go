@@ -0,0 +1,93 @@
+package regexrouter
+
+import (
+	"sync"
+	"time"
+)
+
+// matchCache remembers which route served a method and path so that repeat
+// requests skip the linear scan of the route table. It holds at most size
+// entries, is emptied whenever it fills up, and must be cleared whenever the
+// route table changes. A nil *matchCache is a disabled cache.
+type matchCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[matchKey]int
+}
+
+type matchKey struct {
+	method, path string
+}
+
+func newMatchCache(size int) *matchCache {
+	return &matchCache{size: size, entries: make(map[matchKey]int)}
+}
+
+// get returns the index of the route that last served method and path.
+func (c *matchCache) get(method, path string) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, ok := c.entries[matchKey{method, path}]
+	return i, ok
+}
+
+func (c *matchCache) put(method, path string, i int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		clear(c.entries)
+	}
+	c.entries[matchKey{method, path}] = i
+}
+
+func (c *matchCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// WithMatchCache caches, for up to size distinct method and path pairs, which
+// route served the request, so repeat requests skip scanning the route table.
+// Parameters are still extracted on every request. Each sub-Router mounted by
+// Route gets a cache of the same size for its own table, keyed by the
+// remainder it matches. A cache is reset whenever it fills up or a route is
+// registered or removed. Use WarmCache to populate the top-level cache ahead
+// of traffic.
+func WithMatchCache(size int) Option {
+	return func(mx *Mux) {
+		if size > 0 {
+			mx.routes.cache = newMatchCache(size)
+		}
+	}
+}
+
+// WarmCache pre-populates the match cache (see WithMatchCache) by resolving
+// each method and path pair, smoothing latency for the first requests after a
+// deploy. Paths are matched as given, without WithNormalizer. It does nothing
+// without a match cache, or once routes with conditions (see When) are
+// registered, as those bypass the cache.
+func (mx *Mux) WarmCache(pairs []struct{ Method, Path string }) {
+	if mx.routes.cache == nil {
+		return
+	}
+	mx.routes.mu.RLock()
+	conditional := mx.routes.conditional
+	mx.routes.mu.RUnlock()
+	if conditional {
+		return
+	}
+	mostSpecific := mx.root().mostSpecific
+	for _, p := range pairs {
+		// No request is needed: only conditions read it, and there are none.
+		mx.routes.match(nil, p.Method, p.Path, mostSpecific, time.Time{})
+	}
+}
@@ -0,0 +1,104 @@
+package regexrouter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWarmCache verifies WarmCache populates the match cache, that a cached
+// match dispatches (with parameters) like a scanned one, and that registering
+// a route invalidates the cache.
+func TestWarmCache(t *testing.T) {
+	m := New(WithMatchCache(16))
+	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {})
+	m.Get(`^/users/(?P<id>[0-9]+)$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + URLParam(r, "id")))
+	})
+
+	m.WarmCache([]struct{ Method, Path string }{{http.MethodGet, "/users/42"}})
+	if i, ok := m.routes.cache.get(http.MethodGet, "/users/42"); !ok || i != 1 {
+		t.Fatalf("expected warmed cache entry for route 1, got %d %v", i, ok)
+	}
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	runTestCases(t, ts, []testCase{{
+		name:           "cached match dispatches with params",
+		path:           "/users/42",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusOK,
+		expectedBody:   "user 42",
+	}})
+
+	m.Get(`^/about$`, func(w http.ResponseWriter, r *http.Request) {})
+	if _, ok := m.routes.cache.get(http.MethodGet, "/users/42"); ok {
+		t.Fatal("expected registering a route to clear the cache")
+	}
+}
+
+// TestWarmCacheConditional verifies WarmCache does nothing, rather than
+// matching without a request, once a route has a condition.
+func TestWarmCacheConditional(t *testing.T) {
+	m := New(WithMatchCache(16))
+	m.Host(`^api\.example\.com$`).Get(`^/users$`, func(w http.ResponseWriter, r *http.Request) {})
+	m.Get(`^/about$`, func(w http.ResponseWriter, r *http.Request) {})
+
+	m.WarmCache([]struct{ Method, Path string }{{http.MethodGet, "/users"}, {http.MethodGet, "/about"}})
+	if _, ok := m.routes.cache.get(http.MethodGet, "/about"); ok {
+		t.Fatal("expected no cache entries for a table with conditions")
+	}
+}
+
+// TestSubrouterMatchCache verifies a sub-Router gets its own match cache, keyed
+// by the remainder it matches.
+func TestSubrouterMatchCache(t *testing.T) {
+	m := New(WithMatchCache(16))
+	sub := m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^a$`, func(w http.ResponseWriter, r *http.Request) {})
+		r.Get(`^b$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("b"))
+		})
+	}).(*Mux)
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	for range 2 {
+		runTestCases(t, ts, []testCase{{
+			name:           "sub-route served (cold, then cached)",
+			path:           "/api/b",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "b",
+		}})
+	}
+	if i, ok := sub.routes.cache.get(http.MethodGet, "b"); !ok || i != 1 {
+		t.Fatalf("expected sub-Router cache entry for route 1, got %d %v", i, ok)
+	}
+}
+
+// BenchmarkSubrouterChildren measures dispatch to the last of 50 sub-routes,
+// the worst case for the linear scan, with and without the match cache.
+func BenchmarkSubrouterChildren(b *testing.B) {
+	for name, opts := range map[string][]Option{
+		"scan":  nil,
+		"cache": {WithMatchCache(64)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			m := New(opts...)
+			m.Route(`^/v2/(?P<subroute>.*)$`, func(r Router) {
+				for i := range 50 {
+					r.Get(fmt.Sprintf(`^child%d/(?P<id>[0-9]+)$`, i), func(w http.ResponseWriter, r *http.Request) {})
+				}
+			})
+			req := httptest.NewRequest(http.MethodGet, "/v2/child49/42", nil)
+			w := httptest.NewRecorder()
+			b.ResetTimer()
+			for range b.N {
+				req.Pattern = ""
+				m.ServeHTTP(w, req)
+			}
+		})
+	}
+}
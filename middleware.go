@@ -0,0 +1,439 @@
+package regexrouter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Abort writes a response with status and body, for a middleware ending the
+// request instead of calling its next handler:
+//
+//	if !authorized(r) {
+//		regexrouter.Abort(w, http.StatusUnauthorized, "unauthorized")
+//		return
+//	}
+//	next.ServeHTTP(w, r)
+//
+// Nothing further in the chain runs. A middleware passed to Mux.Wrap runs
+// before matching, so aborting there also skips the post-match middleware
+// (see Mux.UsePostMatch) and After hooks; one added with Use runs after
+// matching, so After hooks still run.
+func Abort(w http.ResponseWriter, status int, body string) {
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+// TrackMiddleware returns a middleware that records label in the request
+// context, for tests asserting the order middleware runs in: a handler reads
+// the labels recorded on the way in with CollectMiddleware.
+//
+//	m.Use(regexrouter.TrackMiddleware("auth"))
+//	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {
+//		fmt.Fprint(w, regexrouter.CollectMiddleware(r)) // [auth]
+//	})
+func TrackMiddleware(label string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			labels := append(slices.Clip(CollectMiddleware(r)), label)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyMiddlewareTrace, labels)))
+		})
+	}
+}
+
+// CollectMiddleware returns the labels recorded by TrackMiddleware for r, in
+// the order the middleware ran.
+func CollectMiddleware(r *http.Request) []string {
+	v, _ := r.Context().Value(ctxKeyMiddlewareTrace).([]string)
+	return v
+}
+
+// RequirePrefix returns a middleware that responds 404 Not Found to any
+// request whose path does not start with prefix, for example to serve only
+// the /v1 API behind a gateway. Pass it to Mux.Wrap so it runs before
+// matching; the 404 comes from the package-level NotFound handler (see
+// SetDefaultNotFoundHandler).
+func RequirePrefix(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				packageNotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AllowMethods returns a handler that serves only requests whose method is
+// one of methods with h, answering any other with the package-level 405 (see
+// SetDefaultMethodNotAllowedHandler) and an Allow header listing methods, for
+// a single endpoint served without a Mux:
+//
+//	http.ListenAndServe(":8080", regexrouter.AllowMethods([]string{"GET", "POST"}, h))
+//
+// Methods are matched case-insensitively; HEAD is allowed only if listed.
+func AllowMethods(methods []string, h http.Handler) http.Handler {
+	allowed := make([]string, 0, len(methods))
+	for _, method := range methods {
+		if method = strings.ToUpper(method); !slices.Contains(allowed, method) {
+			allowed = append(allowed, method)
+		}
+	}
+	slices.Sort(allowed)
+	allow := strings.Join(allowed, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(allowed, r.Method) {
+			w.Header().Set("Allow", allow)
+			packageMethodNotAllowed(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RequireHeaders returns a middleware that responds 400 Bad Request, listing
+// the missing names, to requests lacking any of the named headers (or sending
+// them empty), for example RequireHeaders("X-Api-Version").
+func RequireHeaders(names ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var missing []string
+			for _, name := range names {
+				if r.Header.Get(name) == "" {
+					missing = append(missing, name)
+				}
+			}
+			if len(missing) > 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("missing required headers: " + strings.Join(missing, ", ")))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireHTTPS returns a middleware for requests that arrived over plain
+// HTTP, that is without TLS and without an X-Forwarded-Proto of https from a
+// TLS-terminating proxy. With redirect it sends them a 301 to the same URL
+// with the https scheme; otherwise it responds 403 Forbidden. Only trust
+// X-Forwarded-Proto behind a proxy that sets it.
+func RequireHTTPS(redirect bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if redirect {
+				http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusMovedPermanently)
+				return
+			}
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("forbidden"))
+		})
+	}
+}
+
+// MeterBody returns a middleware that counts the bytes the handler reads from
+// the request body and passes the total to onDone once the handler returns.
+// Only bytes actually read are counted, so a handler that stops early is
+// billed for what it consumed rather than the declared Content-Length.
+func MeterBody(onDone func(r *http.Request, bytes int64)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := &countingBody{ReadCloser: r.Body}
+			if r.Body != nil {
+				// Wrap the body of a copy, leaving the caller's request as it
+				// was.
+				r2 := *r
+				r2.Body = body
+				r = &r2
+			}
+			defer func() { onDone(r, body.n) }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// countingBody counts the bytes read through it for MeterBody.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// DefaultETagLimit is the largest response body, in bytes, that ETag buffers
+// to compute an entity tag.
+const DefaultETagLimit = 1 << 20
+
+// ETag is a middleware for cacheable GET routes that adds a weak entity tag,
+// computed from the response body, to 200 responses to GET and HEAD requests,
+// and answers 304 Not Modified when the request's If-None-Match lists it. An
+// ETag set by the handler is used as is. Computing the tag means buffering the
+// whole response, so the handler's output reaches the client only once it
+// returns, and responses larger than DefaultETagLimit (or that are flushed)
+// are streamed without a tag. Use ETagWithLimit to change the limit.
+func ETag(next http.Handler) http.Handler {
+	return ETagWithLimit(DefaultETagLimit)(next)
+}
+
+// ETagWithLimit returns an ETag middleware that buffers at most max bytes of
+// response body.
+func ETagWithLimit(max int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ew := &etagWriter{w: w, code: http.StatusOK, max: max}
+			next.ServeHTTP(ew, r)
+			if ew.streaming {
+				return
+			}
+			if ew.code == http.StatusOK {
+				tag := w.Header().Get("ETag")
+				if tag == "" {
+					sum := sha256.Sum256(ew.buf.Bytes())
+					tag = fmt.Sprintf(`W/"%x"`, sum[:16])
+					w.Header().Set("ETag", tag)
+				}
+				if etagMatch(r.Header.Get("If-None-Match"), tag) {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			}
+			w.WriteHeader(ew.code)
+			w.Write(ew.buf.Bytes())
+		})
+	}
+}
+
+// etagWriter buffers a response for ETagWithLimit, switching to streaming it
+// once it outgrows max or is flushed.
+type etagWriter struct {
+	w         http.ResponseWriter
+	buf       bytes.Buffer
+	code      int
+	max       int
+	wroteCode bool
+	streaming bool
+}
+
+func (ew *etagWriter) Header() http.Header {
+	return ew.w.Header()
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if ew.streaming || ew.wroteCode {
+		return
+	}
+	ew.code, ew.wroteCode = code, true
+}
+
+func (ew *etagWriter) Write(p []byte) (int, error) {
+	if !ew.streaming && ew.buf.Len()+len(p) > ew.max {
+		ew.stream()
+	}
+	if ew.streaming {
+		return ew.w.Write(p)
+	}
+	return ew.buf.Write(p)
+}
+
+func (ew *etagWriter) Flush() {
+	if !ew.streaming {
+		ew.stream()
+	}
+	http.NewResponseController(ew.w).Flush()
+}
+
+// stream sends the buffered response and passes later writes straight on.
+func (ew *etagWriter) stream() {
+	ew.streaming = true
+	ew.w.WriteHeader(ew.code)
+	ew.w.Write(ew.buf.Bytes())
+	ew.buf = bytes.Buffer{}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (ew *etagWriter) Unwrap() http.ResponseWriter {
+	return ew.w
+}
+
+// etagMatch reports whether an If-None-Match header value lists tag, using
+// the weak comparison RFC 9110 prescribes for If-None-Match.
+func etagMatch(ifNoneMatch, tag string) bool {
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeoutFromHeader returns a middleware that sets a deadline on the request
+// context from the duration in the named header, in time.ParseDuration form
+// (e.g. "1.5s"), capped at max. A missing, malformed or non-positive value
+// gets max. The handler is expected to honor the context; nothing is written
+// to the client when the deadline passes.
+func TimeoutFromHeader(header string, max time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d, err := time.ParseDuration(r.Header.Get(header))
+			if err != nil || d <= 0 || d > max {
+				d = max
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// TimeoutFirstByte returns a middleware that bounds the time until the
+// handler starts its response, rather than its total duration as
+// http.TimeoutHandler does, so streaming responses may run for as long as they
+// keep going. If the handler has not called Write, WriteHeader or Flush within
+// d, the client gets a 504 Gateway Timeout, the request context is canceled,
+// and later writes fail with http.ErrHandlerTimeout.
+func TimeoutFirstByte(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			fw := &firstByteWriter{w: w, header: make(http.Header), started: make(chan struct{})}
+			done := make(chan struct{})
+			var panicVal any
+			go func() {
+				defer func() {
+					panicVal = recover()
+					close(done)
+				}()
+				next.ServeHTTP(fw, r.WithContext(ctx))
+			}()
+			// wait lets the handler finish, re-raising any panic on the
+			// serving goroutine so net/http handles it as usual.
+			wait := func() {
+				<-done
+				if panicVal != nil {
+					panic(panicVal)
+				}
+			}
+
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			select {
+			case <-done:
+				wait()
+			case <-fw.started:
+				wait()
+			case <-timer.C:
+				if !fw.expire() {
+					// The first byte raced the timer and won.
+					wait()
+					return
+				}
+				cancel()
+				w.WriteHeader(http.StatusGatewayTimeout)
+				w.Write([]byte("gateway timeout"))
+			}
+		})
+	}
+}
+
+// firstByteWriter is the ResponseWriter TimeoutFirstByte hands its handler.
+// Headers are buffered until the response starts so that a timeout response
+// never races with the handler setting them; from then on Header returns the
+// underlying writer's, so trailers set after the body reach the client.
+type firstByteWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu      sync.Mutex
+	wrote   bool
+	expired bool
+	started chan struct{} // closed once the response starts
+}
+
+func (fw *firstByteWriter) Header() http.Header {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.wrote {
+		return fw.w.Header()
+	}
+	return fw.header
+}
+
+// start begins the response if it has not begun; fw.mu must be held. It
+// reports false once the timeout has fired.
+func (fw *firstByteWriter) start(code int) bool {
+	if fw.expired {
+		return false
+	}
+	if !fw.wrote {
+		fw.wrote = true
+		close(fw.started)
+		maps.Copy(fw.w.Header(), fw.header)
+		fw.w.WriteHeader(code)
+	}
+	return true
+}
+
+func (fw *firstByteWriter) WriteHeader(code int) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.start(code)
+}
+
+func (fw *firstByteWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if !fw.start(http.StatusOK) {
+		return 0, http.ErrHandlerTimeout
+	}
+	return fw.w.Write(p)
+}
+
+func (fw *firstByteWriter) Flush() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.start(http.StatusOK) {
+		http.NewResponseController(fw.w).Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (fw *firstByteWriter) Unwrap() http.ResponseWriter {
+	return fw.w
+}
+
+// expire marks the response as timed out unless it has already started, and
+// reports whether it did.
+func (fw *firstByteWriter) expire() bool {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.wrote {
+		return false
+	}
+	fw.expired = true
+	return true
+}
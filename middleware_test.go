@@ -0,0 +1,373 @@
+package regexrouter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTimeoutFirstByte verifies a handler that is slow to start gets a 504,
+// while one that starts promptly may stream for longer than the timeout and
+// set trailers once the body is written.
+func TestTimeoutFirstByte(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	m := New()
+	m.Use(TimeoutFirstByte(timeout))
+	m.Get(`^/slow-start$`, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Write([]byte("too late"))
+	})
+	m.Get(`^/slow-stream$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first "))
+		http.NewResponseController(w).Flush()
+		time.Sleep(3 * timeout)
+		w.Write([]byte("second"))
+	})
+	m.Get(`^/trailers$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("body"))
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "1")
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "slow start times out",
+			path:           "/slow-start",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusGatewayTimeout,
+			expectedBody:   "gateway timeout",
+		}, {
+			name:           "slow stream completes",
+			path:           "/slow-stream",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "first second",
+		},
+	})
+
+	resp, err := http.Get(ts.URL + "/trailers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if got := fmt.Sprint(resp.Trailer.Get("X-Checksum"), " ", resp.Trailer.Get("X-Late")); got != "abc 1" {
+		t.Fatalf("expected trailers set after the body to reach the client, got %v", resp.Trailer)
+	}
+}
+
+// TestRequirePrefix verifies requests outside the prefix 404 before matching,
+// even where a route would have matched them.
+func TestRequirePrefix(t *testing.T) {
+	m := New()
+	m.Get(`/x$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("x"))
+	})
+
+	ts := httptest.NewServer(m.Wrap(RequirePrefix("/v1/")))
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "prefixed request proceeds",
+			path:           "/v1/x",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "x",
+		}, {
+			name:           "other prefix rejected",
+			path:           "/v2/x",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+}
+
+// TestAllowMethods verifies a handler wrapped outside a Mux serves its
+// methods and answers others with a 405 and an Allow header.
+func TestAllowMethods(t *testing.T) {
+	h := AllowMethods([]string{"post", http.MethodGet}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method))
+	}))
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != method {
+			t.Fatalf("%s: expected 200 %q, got %d %q", method, method, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Body.String() != "not allowed" {
+		t.Fatalf("expected 405 \"not allowed\", got %d %q", rec.Code, rec.Body.String())
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, POST" {
+		t.Fatalf("expected Allow \"GET, POST\", got %q", allow)
+	}
+}
+
+// TestRequireHeaders verifies requests with every required header are served
+// and others get a 400 naming the missing ones.
+func TestRequireHeaders(t *testing.T) {
+	m := New()
+	m.Use(RequireHeaders("X-Api-Version", "X-Client"))
+	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	for _, tc := range []struct {
+		name     string
+		headers  map[string]string
+		wantCode int
+		wantBody string
+	}{
+		{"all present", map[string]string{"X-Api-Version": "2", "X-Client": "cli"}, http.StatusOK, "ok"},
+		{"one missing", map[string]string{"X-Api-Version": "2"}, http.StatusBadRequest, "missing required headers: X-Client"},
+		{"all missing", nil, http.StatusBadRequest, "missing required headers: X-Api-Version, X-Client"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		if rec.Code != tc.wantCode || rec.Body.String() != tc.wantBody {
+			t.Fatalf("%s: expected %d %q, got %d %q", tc.name, tc.wantCode, tc.wantBody, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// TestETag verifies a matching If-None-Match gets a bodiless 304, a stale one
+// the full response, and a body over the limit no tag at all.
+func TestETag(t *testing.T) {
+	m := New()
+	m.With(ETagWithLimit(8)).Get(`^/doc$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("document"))
+	})
+	m.With(ETagWithLimit(8)).Get(`^/big$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("large document"))
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/doc", nil))
+	tag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || rec.Body.String() != "document" || !strings.HasPrefix(tag, `W/"`) {
+		t.Fatalf("expected 200 %q with a weak ETag, got %d %q, ETag %q", "document", rec.Code, rec.Body.String(), tag)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		ifNoneMatch string
+		wantCode    int
+		wantBody    string
+	}{
+		{"matching tag", tag, http.StatusNotModified, ""},
+		{"matching among several", `"other", ` + tag, http.StatusNotModified, ""},
+		{"stale tag", `W/"stale"`, http.StatusOK, "document"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/doc", nil)
+		req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		if rec.Code != tc.wantCode || rec.Body.String() != tc.wantBody {
+			t.Fatalf("%s: expected %d %q, got %d %q", tc.name, tc.wantCode, tc.wantBody, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/big", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "large document" || rec.Header().Get("ETag") != "" {
+		t.Fatalf("expected untagged 200 %q, got %d %q, ETag %q", "large document", rec.Code, rec.Body.String(), rec.Header().Get("ETag"))
+	}
+}
+
+// TestMeterBody verifies the reported count is the bytes the handler read,
+// whether it drains the body or stops early, and that the caller's request
+// is left alone.
+func TestMeterBody(t *testing.T) {
+	var metered int64
+	m := New()
+	m.Use(MeterBody(func(r *http.Request, n int64) {
+		metered = n
+	}))
+	m.Post(`^/all$`, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	})
+	m.Post(`^/head$`, func(w http.ResponseWriter, r *http.Request) {
+		io.ReadFull(r.Body, make([]byte, 10))
+	})
+
+	for path, want := range map[string]int64{"/all": 1000, "/head": 10} {
+		metered = -1
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(strings.Repeat("x", 1000)))
+		m.ServeHTTP(httptest.NewRecorder(), req)
+		if metered != want {
+			t.Fatalf("%s: expected %d bytes metered, got %d", path, want, metered)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("x"))
+	MeterBody(func(r *http.Request, n int64) {})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	if _, ok := req.Body.(*countingBody); ok {
+		t.Fatal("expected the caller's request to keep its own body")
+	}
+}
+
+// TestTimeoutFromHeader verifies the header sets the deadline, capped at the
+// maximum, and that a malformed value falls back to the maximum.
+func TestTimeoutFromHeader(t *testing.T) {
+	const max = time.Minute
+	m := New()
+	m.Use(TimeoutFromHeader("X-Timeout", max))
+	var remaining time.Duration
+	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ := r.Context().Deadline()
+		remaining = time.Until(deadline)
+	})
+
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"2s", 2 * time.Second},
+		{"1h", max},
+		{"soon", max},
+		{"", max},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Timeout", tc.header)
+		m.ServeHTTP(httptest.NewRecorder(), req)
+		if remaining > tc.want || remaining < tc.want-time.Second {
+			t.Fatalf("header %q: expected deadline about %v away, got %v", tc.header, tc.want, remaining)
+		}
+	}
+}
+
+// TestTrackMiddleware verifies the tracked labels reflect the order root and
+// group middleware run in, without leaking between sibling groups.
+func TestTrackMiddleware(t *testing.T) {
+	m := New()
+	m.Use(TrackMiddleware("1"), TrackMiddleware("2"))
+	collect := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, CollectMiddleware(r))
+	}
+	m.Group(func(r Router) {
+		r.Use(TrackMiddleware("a"))
+		r.Get(`^/a$`, collect)
+	})
+	m.Group(func(r Router) {
+		r.Use(TrackMiddleware("b"))
+		r.Get(`^/b$`, collect)
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "group a",
+			path:           "/a",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "[1 2 a]",
+		}, {
+			name:           "group b",
+			path:           "/b",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "[1 2 b]",
+		},
+	})
+}
+
+// TestRequireHTTPS verifies plain HTTP requests are redirected or rejected,
+// while TLS and forwarded-https requests proceed.
+func TestRequireHTTPS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	for _, tc := range []struct {
+		name         string
+		redirect     bool
+		tls          bool
+		forwarded    string
+		wantCode     int
+		wantLocation string
+	}{
+		{"plain redirected", true, false, "", http.StatusMovedPermanently, "https://example.com/a?b=c"},
+		{"plain rejected", false, false, "", http.StatusForbidden, ""},
+		{"forwarded http rejected", false, false, "http", http.StatusForbidden, ""},
+		{"forwarded https allowed", false, false, "https", http.StatusOK, ""},
+		{"tls allowed", true, true, "", http.StatusOK, ""},
+	} {
+		target := "http://example.com/a?b=c"
+		if tc.tls {
+			target = "https://example.com/a?b=c"
+		}
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-Proto", tc.forwarded)
+		}
+		rec := httptest.NewRecorder()
+		RequireHTTPS(tc.redirect)(ok).ServeHTTP(rec, req)
+		if rec.Code != tc.wantCode || rec.Header().Get("Location") != tc.wantLocation {
+			t.Fatalf("%s: expected %d to %q, got %d to %q", tc.name, tc.wantCode, tc.wantLocation, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+// TestAbort verifies a pre-match middleware that aborts keeps the handler,
+// post-match middleware and After hooks from running.
+func TestAbort(t *testing.T) {
+	var ran []string
+	m := New()
+	m.UsePostMatch(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ran = append(ran, "post-match")
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.After(func(r *http.Request) { ran = append(ran, "after") })
+	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {
+		ran = append(ran, "handler")
+	})
+	h := m.Wrap(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				Abort(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusUnauthorized || rec.Body.String() != "unauthorized" {
+		t.Fatalf("expected 401 %q, got %d %q", "unauthorized", rec.Code, rec.Body.String())
+	}
+	if len(ran) != 0 {
+		t.Fatalf("expected nothing to run after abort, got %v", ran)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got := strings.Join(ran, " "); got != "post-match handler after" {
+		t.Fatalf("expected the full chain when authorized, got %q", got)
+	}
+}
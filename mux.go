@@ -1,11 +1,24 @@
 package regexrouter
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
+	"math/rand/v2"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
 	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var _ Router = &Mux{}
@@ -25,6 +38,10 @@ const methodAll = "*"
 // the "{1,3}" quantifier), which made the old comma-joined form ambiguous.
 const routePatternSeparator = " > "
 
+// variantSeparator separates a method from a variant name in the method a
+// variant handler is registered under, as in "GET@v2"; see ContextWithVariant.
+const variantSeparator = "@"
+
 // SubrouteParam is the name of the optional capture group in a Route pattern
 // whose match becomes the path that the mounted sub-Router matches against. For
 // example:
@@ -38,6 +55,11 @@ const routePatternSeparator = " > "
 // value is also readable as an ordinary parameter via URLParam(r, SubrouteParam).
 const SubrouteParam = "subroute"
 
+// RestParam is the name of the parameter holding the path after the leading
+// segments matched by a Segments route, such as "/c/d" for "/a/b/c/d" with two
+// segments.
+const RestParam = "rest"
+
 // contextKey is an unexported type used for the router's own context keys so
 // they cannot collide with keys defined in other packages.
 type contextKey int
@@ -46,12 +68,24 @@ const (
 	// ctxKeyRequestPath carries the remaining path a sub-Router should match
 	// against, set by Route before delegating to the sub-Router.
 	ctxKeyRequestPath contextKey = iota
-)
 
-// paramKey namespaces user-defined regex capture-group names stored in the
-// request context, keeping them from colliding with the router's internal
-// keys or with context keys from other packages.
-type paramKey string
+	// ctxKeyParams carries the *params of the matched route; see params.
+	ctxKeyParams
+
+	// ctxKeyMiddlewareTrace carries the labels recorded by TrackMiddleware.
+	ctxKeyMiddlewareTrace
+
+	// ctxKeyNotFound carries the notFoundInfo of an unmatched request; see
+	// NotFoundInfo.
+	ctxKeyNotFound
+
+	// ctxKeyVariant carries the handler variant selected with
+	// ContextWithVariant.
+	ctxKeyVariant
+
+	// ctxKeyDispatchDepth counts the nested Dispatch calls serving a request.
+	ctxKeyDispatchDepth
+)
 
 // URLParam returns the value of the named regex capture group for the current
 // request, or "" if no such group matched.
@@ -62,10 +96,149 @@ func URLParam(r *http.Request, name string) string {
 // URLParamFromCtx returns the value of the named regex capture group stored in
 // ctx, or "" if no such group matched.
 func URLParamFromCtx(ctx context.Context, name string) string {
-	v, _ := ctx.Value(paramKey(name)).(string)
+	v, _ := paramsFromCtx(ctx).get(name)
 	return v
 }
 
+// URLParams returns every named parameter of the request, including those
+// captured by enclosing Routes, keyed by name. The map is a copy.
+func URLParams(r *http.Request) map[string]string {
+	return paramsFromCtx(r.Context()).all()
+}
+
+// URLParamNames returns the names of the named capture groups of the matched
+// route, in pattern order, for code that handles the parameters of any route;
+// read the values with URLParam. Inside a sub-Router these are the names of
+// the sub-route's groups only.
+func URLParamNames(r *http.Request) []string {
+	p := paramsFromCtx(r.Context())
+	if p == nil {
+		return nil
+	}
+	var names []string
+	for _, name := range p.names {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// URLParamIndex returns the value of the i-th capture group of the matched
+// route, counting from 0 and including unnamed groups, or "" if there is no
+// such group. URLParamIndex(r, 0) is the group regexp calls $1.
+func URLParamIndex(r *http.Request, i int) string {
+	captures := URLParamsAll(r)
+	if i < 0 || i >= len(captures) {
+		return ""
+	}
+	return captures[i]
+}
+
+// URLParamsAll returns the values of every capture group of the matched
+// route, named and unnamed, in pattern order. Inside a sub-Router these are
+// the captures of the sub-route. The slice must not be modified.
+func URLParamsAll(r *http.Request) []string {
+	p := paramsFromCtx(r.Context())
+	if p == nil || len(p.matches) == 0 {
+		return nil
+	}
+	return p.matches[1:]
+}
+
+// MatchedText returns the text of the path the matched route's pattern
+// matched (regexp's group 0), or "" outside a matched route. For an anchored
+// pattern that is the whole path; for an unanchored one, only the part it
+// matched. Inside a sub-Router it is the text matched in the remainder.
+func MatchedText(r *http.Request) string {
+	p := paramsFromCtx(r.Context())
+	if p == nil || len(p.matches) == 0 {
+		return ""
+	}
+	return p.matches[0]
+}
+
+// MatchedViaAny reports whether the request was dispatched to a handler
+// registered for all methods (Handle, HandleFunc) rather than for its method
+// specifically. Inside a sub-Router it describes the sub-route.
+func MatchedViaAny(r *http.Request) bool {
+	p := paramsFromCtx(r.Context())
+	return p != nil && p.viaAny
+}
+
+// RouteName returns the name given with Mux.Name to the route that matched
+// the request, or "" if it has none. Inside a sub-Router it is the name of the
+// innermost named route, so a named mount is reported for unnamed children.
+func RouteName(r *http.Request) string {
+	for p := paramsFromCtx(r.Context()); p != nil; p = p.parent {
+		if p.name != "" {
+			return p.name
+		}
+	}
+	return ""
+}
+
+// ContextWithVariant returns a copy of ctx selecting the named handler
+// variant, for example to route a share of requests to an experimental
+// handler without changing the route table. A variant handler is registered
+// under the method followed by "@" and the variant name:
+//
+//	m.Get(`^/checkout$`, checkout)
+//	m.MethodFunc("GET@v2", `^/checkout$`, checkoutV2)
+//
+// When a request whose context selects variant v matches a route with a
+// handler for its method, the route's handler for method@v is used instead,
+// if it has one. Variants are picked after matching but before the Use
+// middleware runs, so set the context in middleware around the whole router
+// (see Mux.Wrap). Variant names are case-insensitive.
+func ContextWithVariant(ctx context.Context, variant string) context.Context {
+	return context.WithValue(ctx, ctxKeyVariant, variant)
+}
+
+// RouteMeta returns the metadata value attached with Mux.Meta under key to
+// the route that matched the request, or nil. Inside a sub-Router it is the
+// metadata of the sub-route.
+func RouteMeta(r *http.Request, key string) any {
+	for p := paramsFromCtx(r.Context()); p != nil; p = p.parent {
+		if p.meta != nil {
+			return p.meta[key]
+		}
+	}
+	return nil
+}
+
+// URLParamDecoded returns the named capture group like URLParam, with percent-
+// encoding decoded by url.PathUnescape. Captures are taken from the decoded
+// r.URL.Path by default, so this is needed only when matching the encoded form,
+// as under WithMatchRawURI.
+func URLParamDecoded(r *http.Request, name string) (string, error) {
+	return url.PathUnescape(URLParam(r, name))
+}
+
+// URLParamSegments returns the named capture group split into its non-empty
+// "/"-separated segments, such as the trailing path of a file route: a
+// capture of "a/b/c" gives ["a" "b" "c"]. It returns an empty slice if the
+// group did not match.
+func URLParamSegments(r *http.Request, name string) []string {
+	return strings.FieldsFunc(URLParam(r, name), func(c rune) bool { return c == '/' })
+}
+
+// notFoundInfo describes an unmatched request; see NotFoundInfo.
+type notFoundInfo struct {
+	path      string
+	subrouter bool
+}
+
+// NotFoundInfo describes the miss a not-found handler (or WithOnUnmatched
+// callback) is responding to: path is the path that no route matched, which
+// for a miss inside a sub-Router is the remainder it matched against, and
+// subrouter reports whether the miss was inside one. Outside a not-found
+// handler it returns "" and false.
+func NotFoundInfo(r *http.Request) (path string, subrouter bool) {
+	info, _ := r.Context().Value(ctxKeyNotFound).(notFoundInfo)
+	return info.path, info.subrouter
+}
+
 type Mux struct {
 	// Custom method not allowed handler
 	methodNotAllowedHandler http.HandlerFunc
@@ -77,6 +250,9 @@ type Mux struct {
 	// Custom route not found handler
 	notFoundHandler http.HandlerFunc
 
+	// Custom handler for errors returned by HandlerFuncE routes
+	errorHandler func(http.ResponseWriter, *http.Request, error)
+
 	// Debug logger; nil means fall back to the parent's, then a no-op. Set via
 	// WithLogger. Resolved through log().
 	logger Logger
@@ -84,30 +260,357 @@ type Mux struct {
 	// The middleware stack
 	middlewares []func(http.Handler) http.Handler
 
+	// Names given with UseNamed to middlewares, by index; shorter than
+	// middlewares, or "", where unnamed.
+	middlewareNames []string
+
 	// Controls the behaviour of middleware chain generation when a mux
 	// is registered as an inline group inside another mux.
 	inline bool
 
-	// Set once any route has been registered through this mux (or, for an
-	// inline mux, through the parent it appends to). Used to reject Use()
-	// calls made after routes, whose middleware would otherwise be dropped.
-	hasRoutes bool
+	// Match against the request URI (path and query) instead of the path.
+	// Set via WithMatchRawURI.
+	matchRawURI bool
+
+	// Applied to the path before matching; nil leaves it unchanged. Set via
+	// WithNormalizer.
+	normalizer func(string) string
+
+	// Respond 400 to paths with control characters; see
+	// WithRejectControlChars.
+	rejectControlChars bool
+
+	// Wrappers of each matched handler at dispatch, given the route's
+	// pattern; see UsePostMatch and Around.
+	postMatch []func(next http.Handler, pattern string) http.Handler
+
+	// Hooks run after each matched handler returns; see After.
+	afterHooks []func(*http.Request)
+
+	// Respond 400 to GET, HEAD and DELETE requests with a body; see
+	// WithRejectBodyOnGet.
+	rejectBodyOnGet bool
+
+	// Compile patterns case-insensitively; see WithCaseInsensitive.
+	caseInsensitive bool
+
+	// Longest a route table may be scanned for a request; see
+	// WithMatchTimeout.
+	matchTimeout time.Duration
+
+	// Report matching time in a Server-Timing header; see WithServerTiming.
+	serverTiming bool
+
+	// Reject top-level patterns not starting with "/"; see
+	// WithRequireLeadingSlash.
+	requireLeadingSlash bool
+
+	// Compile time of each pattern registered on the router and its
+	// sub-Routers, when enabled by WithCompileStats; guarded by compileMu.
+	compileMu    sync.Mutex
+	compileStats map[string]time.Duration
+
+	// Source of MethodCanary's choices when seeded by WithCanarySeed;
+	// guarded by canaryMu. nil uses the global source.
+	canaryMu   sync.Mutex
+	canaryRand *rand.Rand
+
+	// Status for requests whose path matched only under another host; see
+	// WithMisdirectedStatus.
+	misdirectedStatus int
+
+	// Answer method mismatches like unmatched paths; see
+	// WithHideMethodNotAllowed.
+	hideMethodNotAllowed bool
+
+	// Status for requests whose context is done before dispatch; 0 means
+	// 503. Set via WithCanceledStatus.
+	canceledStatus int
+
+	// Method Handle and HandleFunc register under; see WithDefaultMethod.
+	defaultMethod string
+
+	// Leave r.PathValue unset; see WithPathValues.
+	noPathValues bool
+
+	// Leave r.Pattern unset; see WithRecordPattern.
+	noRecordPattern bool
+
+	// Expose query parameters through URLParam; see WithCaptureQuery.
+	captureQuery bool
+
+	// Echo TRACE requests to routes without a TRACE handler; see
+	// WithTraceEcho.
+	traceEcho bool
+
+	// Match against the cleaned path; see WithMatchCleanedPath.
+	matchCleaned bool
+
+	// Skip internal debug logging altogether; see WithSilenceInternalLogs.
+	silenceLogs bool
+
+	// Answers OPTIONS requests without an OPTIONS route; see GlobalOptions.
+	globalOptions http.HandlerFunc
+
+	// Called for every request that matches no route; see WithOnUnmatched.
+	onUnmatched func(*http.Request)
+
+	// Pattern of the route most recently registered through this mux; see
+	// Name.
+	lastPattern string
+
+	// Request predicate for routes registered on this inline mux; see When.
+	cond *condition
+
+	// Literal path prepended to the patterns registered on this inline mux;
+	// see Prefix.
+	prefix string
+
+	// Prefer the most literal matching pattern over the first registered.
+	// Set via WithMostSpecificMatch.
+	mostSpecific bool
 
 	routes routes
 }
 
+// routes is a mux's route table. mu guards rts so routes can be registered
+// and removed while the mux is serving; handlers are never called with it
+// held.
 type routes struct {
+	mu  sync.RWMutex
 	rts []route
+
+	// Set by WithMatchCache; nil disables caching. Cleared on every change
+	// to rts.
+	cache *matchCache
+
+	// Set once a route with a condition (see When) is added. Which route
+	// serves a method and path then depends on the rest of the request, so
+	// the match cache is bypassed.
+	conditional bool
+
+	// Called after every change to rts; see OnRouteChange.
+	observers []func(RouteEvent)
+
+	// Set by WithTrieIndex. trie indexes rts, or is nil until the next match
+	// after a change to rts; it is built under trieMu while mu is held for
+	// reading.
+	indexed bool
+	trieMu  sync.Mutex
+	trie    *prefixTrie
+}
+
+func (r *routes) insert(i int, rt route) {
+	r.rts = slices.Insert(r.rts, i, rt)
+	r.conditional = r.conditional || rt.cond != nil
+	r.cache.clear()
+	r.trie = nil
 }
 
-func (r *routes) append(rt route) {
-	r.rts = append(r.rts, rt)
+// find returns the route registered under pattern and cond, or nil. The
+// caller must hold mu.
+func (r *routes) find(pattern string, cond *condition) *route {
+	for i := range r.rts {
+		if r.rts[i].pattern == pattern && r.rts[i].cond == cond {
+			return &r.rts[i]
+		}
+	}
+	return nil
 }
 
 type route struct {
+	// The pattern as registered, which identifies the route. It differs from
+	// regex.String() under WithCaseInsensitive and WithTrieIndex.
+	pattern string
+
+	// Under WithTrieIndex, the literal text the pattern starts with, which
+	// regex, compiled from the rest of the pattern, does not include; see
+	// splitLiteral.
+	literal string
+
 	regex         *regexp.Regexp
 	methodhandler map[string]http.Handler
 	varNames      []string
+
+	// Names of the named middleware wrapping each method's handler; see
+	// MiddlewareFor.
+	middleware map[string][]string
+
+	// Extra predicate the request must satisfy, or nil; see When.
+	cond *condition
+
+	// Set by Mux.Name; see RouteName.
+	name string
+
+	// Set by Mux.Meta; see RouteInfo.
+	meta map[string]any
+
+	// Number of characters the pattern matches literally; see
+	// WithMostSpecificMatch.
+	specificity int
+
+	// The sub-Router mounted by Route on this pattern, if any. Kept so a
+	// repeated Route on the same pattern merges into it rather than replacing
+	// the first mount's entry point.
+	subrouter *Mux
+}
+
+// handler returns the handler rt has for method, falling back to its
+// all-methods handler, in which case viaAny is true.
+func (rt *route) handler(method string) (h http.Handler, viaAny, ok bool) {
+	if h, ok := rt.methodhandler[method]; ok {
+		return h, false, true
+	}
+	h, ok = rt.methodhandler[methodAll]
+	return h, ok, ok
+}
+
+// find returns the FindStringSubmatch result of the route's pattern for path.
+func (rt *route) find(path string) []string {
+	if rt.literal == "" {
+		return rt.regex.FindStringSubmatch(path)
+	}
+	if !strings.HasPrefix(path, rt.literal) {
+		return nil
+	}
+	matches := rt.regex.FindStringSubmatch(path[len(rt.literal):])
+	if matches != nil {
+		matches[0] = path[:len(rt.literal)+len(matches[0])]
+	}
+	return matches
+}
+
+// matchString reports whether the route's pattern matches path.
+func (rt *route) matchString(path string) bool {
+	if rt.literal == "" {
+		return rt.regex.MatchString(path)
+	}
+	return strings.HasPrefix(path, rt.literal) && rt.regex.MatchString(path[len(rt.literal):])
+}
+
+// methods returns the methods rt has handlers for, sorted, leaving out
+// variant handlers (see ContextWithVariant).
+func (rt *route) methods() []string {
+	var methods []string
+	for method := range rt.methodhandler {
+		if !strings.Contains(method, variantSeparator) {
+			methods = append(methods, method)
+		}
+	}
+	slices.Sort(methods)
+	return methods
+}
+
+// routeMatch is the outcome of matching a request against a route table.
+type routeMatch struct {
+	route   route
+	handler http.Handler // nil when no route serves the method
+	matches []string     // FindStringSubmatch result for route
+
+	// viaAny reports that handler was registered for all methods (Handle or
+	// HandleFunc) rather than for the request's method specifically.
+	viaAny bool
+
+	// pathMatched reports whether some route matched the path but had no
+	// handler for the method, distinguishing 405 (Method Not Allowed) from
+	// 404 (Not Found).
+	pathMatched bool
+
+	// The methods served by the routes that matched the path, sorted, when
+	// none served the request's method; reported in the 405's Allow header.
+	allowed []string
+
+	// timedOut reports that the deadline passed before matching finished.
+	timedOut bool
+
+	// misdirected reports that a route matched the path but not the
+	// request's host; see WithMisdirectedStatus.
+	misdirected bool
+
+	// The position of route in the table, or -1, and how many patterns
+	// were run to find it; see MatchN.
+	index, scanned int
+}
+
+// match returns the first route whose pattern matches path, whose condition
+// (if any) req satisfies, and that has a handler for method. With
+// mostSpecific, every route is considered and the matching one with the
+// highest specificity wins instead, ties going to the earliest registered.
+// If deadline is non-zero and passes between routes, matching stops with
+// timedOut set.
+func (r *routes) match(req *http.Request, method, path string, mostSpecific bool, deadline time.Time) routeMatch {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cache := r.cache
+	if r.conditional {
+		cache = nil
+	}
+	var m routeMatch
+	if i, ok := cache.get(method, path); ok {
+		rt := r.rts[i]
+		if handler, viaAny, ok := rt.handler(method); ok {
+			m.route, m.handler, m.viaAny = rt, handler, viaAny
+			m.matches = rt.find(path)
+			m.index = i
+			return m
+		}
+	}
+	m.index = -1
+	var candidates [][]int
+	if r.indexed {
+		var buf [8][]int
+		candidates = r.index().candidates(path, buf[:0])
+	}
+	for k := 0; ; k++ {
+		i := k
+		if r.indexed {
+			i = nextCandidate(candidates)
+		}
+		if i < 0 || i >= len(r.rts) {
+			break
+		}
+		rt := r.rts[i]
+		if k > 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			return routeMatch{timedOut: true}
+		}
+		if m.handler != nil && rt.specificity <= m.route.specificity {
+			continue
+		}
+		m.scanned++
+		matches := rt.find(path)
+		if len(matches) <= 0 {
+			continue
+		}
+		if !rt.cond.ok(req) {
+			m.misdirected = m.misdirected || rt.cond.hostFailed(req)
+			continue
+		}
+		handler, viaAny, ok := rt.handler(method)
+		if !ok {
+			// This pattern matched the path but has no handler for the
+			// method. Keep scanning: another overlapping pattern may. A
+			// route with only variant handlers serves no method itself.
+			for _, method := range rt.methods() {
+				m.pathMatched = true
+				if !slices.Contains(m.allowed, method) {
+					m.allowed = append(m.allowed, method)
+				}
+			}
+			continue
+		}
+		m.route, m.handler, m.matches, m.viaAny = rt, handler, matches, viaAny
+		m.index = i
+		if !mostSpecific {
+			break
+		}
+	}
+	if m.handler != nil {
+		cache.put(method, path, m.index)
+		m.allowed = nil
+	} else {
+		slices.Sort(m.allowed)
+	}
+	return m
 }
 
 // Logger is the minimal logging surface regexrouter uses. *slog.Logger
@@ -138,11 +641,216 @@ func WithMethodNotAllowedHandler(h http.HandlerFunc) Option {
 	return func(mx *Mux) { mx.methodNotAllowedHandler = h }
 }
 
+// WithErrorHandler sets the handler invoked when a HandlerFuncE route returns a
+// non-nil error. By default the error yields a plain 500 Internal Server Error.
+func WithErrorHandler(h func(http.ResponseWriter, *http.Request, error)) Option {
+	return func(mx *Mux) { mx.errorHandler = h }
+}
+
+// WithOnUnmatched sets a callback invoked for every request that matches no
+// route, just before the NotFound handler runs, for example to record missing
+// routes. It must not write a response; the NotFound handler still does. It is
+// also called for misses inside sub-Routers.
+func WithOnUnmatched(fn func(*http.Request)) Option {
+	return func(mx *Mux) { mx.onUnmatched = fn }
+}
+
 // WithLogger sets the debug logger. By default the router logs nothing.
 func WithLogger(l Logger) Option {
 	return func(mx *Mux) { mx.logger = l }
 }
 
+// WithMatchRawURI makes the router match patterns against the raw request URI
+// (path and query, as in r.RequestURI) instead of just r.URL.Path, so patterns
+// can include the query string, e.g. `^/legacy\?page=home$`. Note the URI is
+// not percent-decoded. Sub-Routers still match against their remainder.
+func WithMatchRawURI() Option {
+	return func(mx *Mux) { mx.matchRawURI = true }
+}
+
+// WithNormalizer sets a function applied to the request path before matching,
+// for example Unicode NFC normalization so that precomposed and decomposed
+// forms of the same character both match:
+//
+//	New(WithNormalizer(norm.NFC.String)) // golang.org/x/text/unicode/norm
+//
+// Captured parameters are taken from the normalized path. The router itself
+// has no dependency on a normalization package.
+func WithNormalizer(fn func(string) string) Option {
+	return func(mx *Mux) { mx.normalizer = fn }
+}
+
+// WithMatchCleanedPath makes the router match routes against a cleaned copy
+// of the request path, with repeated slashes collapsed and "." and ".."
+// elements resolved as by path.Clean (a trailing slash is kept), so
+// "/foo//bar" matches `^/foo/bar$` without a redirect. Handlers still see the
+// original r.URL.Path; captured parameters come from the cleaned path. It
+// applies before any WithNormalizer function.
+func WithMatchCleanedPath() Option {
+	return func(mx *Mux) { mx.matchCleaned = true }
+}
+
+// WithServerTiming makes the router add a Server-Timing response header
+// reporting the time the top-level router spent matching the request, in
+// milliseconds, as in "route;dur=0.012", for browser developer tools. It is
+// set before the handler runs, so it appears on every response, including 404
+// and 405 responses. The time sub-Routers spend is not included.
+func WithServerTiming() Option {
+	return func(mx *Mux) { mx.serverTiming = true }
+}
+
+// WithRequireLeadingSlash makes registering a top-level route whose pattern
+// does not begin with "/", after an optional "^", panic, catching patterns
+// like `users$` that were meant to be anchored to the start of the path.
+// Patterns registered on sub-Routers match the relative remainder, such as
+// `^$` or `^users$`, and are not checked.
+func WithRequireLeadingSlash() Option {
+	return func(mx *Mux) { mx.requireLeadingSlash = true }
+}
+
+// WithCompileStats makes the router record how long each route pattern
+// registered on it, or on its sub-Routers, took to compile, reported by
+// CompileStats and logged at debug level, to find expensive patterns in large
+// route tables. Patterns passed to MethodRegexp are already compiled and are
+// not included.
+func WithCompileStats() Option {
+	return func(mx *Mux) { mx.compileStats = make(map[string]time.Duration) }
+}
+
+// WithCanarySeed makes the handlers chosen by MethodCanary follow a random
+// sequence determined by seed, for reproducible tests. By default choices use
+// an unseeded source.
+func WithCanarySeed(seed uint64) Option {
+	return func(mx *Mux) { mx.canaryRand = rand.New(rand.NewPCG(seed, seed)) }
+}
+
+// WithHideMethodNotAllowed makes the router answer a request whose path
+// matches a route but whose method does not as if no route matched, with the
+// NotFound handler and no Allow header, rather than with a 405, so clients
+// cannot probe which paths exist. Routes added with Resource answer
+// unsupported methods the same way.
+func WithHideMethodNotAllowed() Option {
+	return func(mx *Mux) { mx.hideMethodNotAllowed = true }
+}
+
+// WithCanceledStatus sets the status, 503 Service Unavailable by default,
+// answered without routing to requests whose context is already done when
+// they reach the router, for example 499 to log them nginx-style. Nothing is
+// written when the client has disconnected, since no one is left to read the
+// response: net/http reports that by canceling the context of a request it
+// serves (one carrying http.ServerContextKey) without a cause. Middleware
+// around the router that cancels such requests itself should give a cause
+// with context.WithCancelCause to have the status written.
+func WithCanceledStatus(code int) Option {
+	return func(mx *Mux) { mx.canceledStatus = code }
+}
+
+// WithMisdirectedStatus sets the status, normally 421 Misdirected Request,
+// answered instead of a 404 when no route matches the request but one
+// registered through Mux.Host would have matched its path under another host,
+// as for a connection reused across hosts. By default such requests get a 404.
+func WithMisdirectedStatus(code int) Option {
+	return func(mx *Mux) { mx.misdirectedStatus = code }
+}
+
+// WithDefaultMethod makes Handle and HandleFunc, on the router and its
+// sub-Routers, register their handlers for method only instead of for all
+// methods, for example WithDefaultMethod(http.MethodPost) for a service whose
+// routes are all POST, so that other methods get a 405. Route mounts,
+// Resource and Redirect still match all methods.
+func WithDefaultMethod(method string) Option {
+	return func(mx *Mux) { mx.defaultMethod = method }
+}
+
+// WithPathValues sets whether the router also exposes named capture groups
+// through the standard http.Request.PathValue, as r.PathValue("id"), which it
+// does by default. URLParam works either way.
+func WithPathValues(set bool) Option {
+	return func(mx *Mux) { mx.noPathValues = !set }
+}
+
+// WithRecordPattern sets whether the router records the matched route's
+// pattern in r.Pattern, which it does by default. Turning recording off lets
+// a route with no capture groups, name or metadata, matched by a handler
+// for the request's method, receive the request unchanged, without allocating
+// a new request and context; MatchedText and URLParamsAll then report nothing
+// for it. Routes of a sub-Router are not spared, so that they never report the
+// parameters of their mount.
+func WithRecordPattern(record bool) Option {
+	return func(mx *Mux) { mx.noRecordPattern = !record }
+}
+
+// WithCaptureQuery makes query parameters readable with URLParam like
+// captured path parameters, so URLParam(r, "page") works whether page came
+// from the path or the query string. A path parameter, including one captured
+// by an enclosing Route, takes precedence over a query parameter of the same
+// name; for a query parameter given more than once, the first value is used.
+func WithCaptureQuery() Option {
+	return func(mx *Mux) { mx.captureQuery = true }
+}
+
+// WithTraceEcho makes the router answer a TRACE request to a path that some
+// route matches, but that has no handler for TRACE, by echoing the request
+// line and headers back with Content-Type message/http, as RFC 9110 describes.
+// Authorization, Proxy-Authorization and Cookie headers are left out of the
+// echo. TRACE is off by default: such requests get a 405 like any other
+// unregistered method.
+func WithTraceEcho() Option {
+	return func(mx *Mux) { mx.traceEcho = true }
+}
+
+// WithMatchTimeout bounds the time spent matching a request against a route
+// table, as a safety valve for huge route tables, expensive patterns or slow
+// When conditions meeting adversarial input. Elapsed time is checked between
+// routes, so a single pattern is never interrupted; once d has passed the
+// request gets a 503 Service Unavailable. Each sub-Router's table gets its
+// own budget of d.
+func WithMatchTimeout(d time.Duration) Option {
+	return func(mx *Mux) { mx.matchTimeout = d }
+}
+
+// WithMostSpecificMatch replaces first-match-wins with most-specific-wins:
+// among the routes matching a request, the one whose pattern has the most
+// literal characters is dispatched, so `^/items/new$` beats
+// `^/items/(?P<id>[^/]+)$` whichever is registered first. Ties still go to
+// the earliest registered route. Every route is tested on every request, so
+// this trades some matching speed for order independence. Sub-Routers follow
+// the setting of the root mux.
+func WithMostSpecificMatch() Option {
+	return func(mx *Mux) { mx.mostSpecific = true }
+}
+
+// WithRejectControlChars makes the router respond 400 Bad Request, before
+// matching, to requests whose decoded path contains an ASCII control character
+// such as a NUL byte (%00), keeping log-injection and request-smuggling
+// attempts away from handlers.
+func WithRejectControlChars() Option {
+	return func(mx *Mux) { mx.rejectControlChars = true }
+}
+
+// WithRejectBodyOnGet makes the router respond 400 Bad Request, before
+// matching, to GET, HEAD and DELETE requests that carry a body (a non-zero
+// Content-Length, or a chunked body of unknown length), as some security
+// policies require.
+func WithRejectBodyOnGet() Option {
+	return func(mx *Mux) { mx.rejectBodyOnGet = true }
+}
+
+// WithCaseInsensitive makes patterns registered on the router, and on its
+// sub-Routers, match case-insensitively, as if each began with (?i). Captured
+// parameters keep the casing of the request path. Patterns are still
+// identified, and reported in r.Pattern, as registered.
+func WithCaseInsensitive() Option {
+	return func(mx *Mux) { mx.caseInsensitive = true }
+}
+
+// WithSilenceInternalLogs turns off the router's internal debug logging,
+// including on sub-Routers, so the 405 path does not even build the log
+// attributes a Logger would discard.
+func WithSilenceInternalLogs() Option {
+	return func(mx *Mux) { mx.silenceLogs = true }
+}
+
 // New returns a newly initialized Mux that implements the Router interface,
 // configured by the given options. Call New() for defaults, or pass options
 // such as WithNotFoundHandler to customize behavior.
@@ -159,25 +867,123 @@ func New(opts ...Option) *Mux {
 }
 
 // ValidPattern reports whether pattern is a valid route pattern, i.e. a
-// compilable regular expression, returning the compilation error otherwise.
+// compilable regular expression with no repeated capture group name,
+// returning the error otherwise.
 // The registration methods (Get, Method, Route, ...) panic on an invalid
 // pattern, so use ValidPattern to check dynamically-built patterns before
 // registering them.
 func ValidPattern(pattern string) error {
-	_, err := regexp.Compile(pattern)
-	return err
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	return checkCaptureNames(re)
 }
 
-func (mx *Mux) Use(middlewares ...func(http.Handler) http.Handler) {
-	// Middleware chains are baked into each handler at registration time, so a
-	// middleware added after a route would silently never run. Fail loudly
-	// instead of dropping it.
-	if mx.hasRoutes {
-		panic("regexrouter: all middlewares must be registered before routes")
+// checkCaptureNames rejects patterns that repeat a capture group name. Go's
+// regexp allows it, but only one value per name can be exposed as a
+// parameter, so the duplicate is almost certainly a mistake.
+func checkCaptureNames(re *regexp.Regexp) error {
+	seen := make(map[string]bool)
+	for _, name := range captureNames(re) {
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate capture group name %q", name)
+		}
+		seen[name] = true
 	}
+	return nil
+}
+
+// Use appends middlewares to the mux's stack. Middleware chains are baked into
+// each handler at registration time, so Use affects only routes registered
+// afterwards: on this mux, on inline muxes (With/Group) derived from it, and
+// on sub-Routers mounted by Route after the call. Routes registered before
+// Use are left unwrapped.
+func (mx *Mux) Use(middlewares ...func(http.Handler) http.Handler) {
 	mx.middlewares = append(mx.middlewares, middlewares...)
 }
 
+// UseRetroactive wraps the handlers of every route already registered on mx,
+// including those inside mounted sub-Routers, in middlewares, as the outermost
+// layers of their chains. Each route's handlers are wrapped once: a sub-Router
+// mount is not wrapped itself, its routes are. Routes registered afterwards are
+// not affected; call Use as well for those.
+func (mx *Mux) UseRetroactive(middlewares ...Middleware) {
+	t := mx.table()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, rt := range t.rts {
+		if rt.subrouter != nil {
+			rt.subrouter.UseRetroactive(middlewares...)
+			continue
+		}
+		for method, h := range rt.methodhandler {
+			for i := len(middlewares) - 1; i >= 0; i-- {
+				h = middlewares[i](h)
+			}
+			rt.methodhandler[method] = h
+		}
+	}
+}
+
+// UseNamed adds middleware to the stack like Use, under name, which
+// MiddlewareFor reports for the routes it wraps.
+func (mx *Mux) UseNamed(name string, middleware func(http.Handler) http.Handler) {
+	for len(mx.middlewareNames) < len(mx.middlewares) {
+		mx.middlewareNames = append(mx.middlewareNames, "")
+	}
+	mx.middlewares = append(mx.middlewares, middleware)
+	mx.middlewareNames = append(mx.middlewareNames, name)
+}
+
+// MiddlewareFor returns the names, as given to UseNamed, of the middleware
+// wrapping the handler registered on mx for method and pattern, outermost
+// (first to run) first, or nil if there is no such handler. Middleware added
+// without a name is left out. For a route inside a sub-Router, the middleware
+// of its parents, which wraps the sub-Router's mount, is not included. Use
+// "*" as the method for a Handle/HandleFunc registration. Inside Prefix,
+// pattern is given as registered there, without the prefix.
+func (mx *Mux) MiddlewareFor(method, pattern string) []string {
+	pattern = mx.prefixed(pattern)
+	if method != methodAll {
+		method = strings.ToUpper(method)
+	}
+	t := mx.table()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rt := t.find(pattern, mx.condition())
+	if rt == nil {
+		return nil
+	}
+	return slices.Clone(rt.middleware[method])
+}
+
+// namedMiddleware returns the names of the named middleware applied to
+// routes registered on mx, outermost first.
+func (mx *Mux) namedMiddleware() []string {
+	return slices.DeleteFunc(mx.middlewareStackNames(), func(name string) bool { return name == "" })
+}
+
+// middlewareStackNames returns the UseNamed names of the stack reported by
+// Middlewares, with "" for unnamed middleware.
+func (mx *Mux) middlewareStackNames() []string {
+	var names []string
+	if mx.parent != nil && mx.inline {
+		names = mx.parent.middlewareStackNames()
+	}
+	for i := range mx.middlewares {
+		name := ""
+		if i < len(mx.middlewareNames) {
+			name = mx.middlewareNames[i]
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
 func (mx *Mux) With(middlewares ...func(http.Handler) http.Handler) Router {
 	return &Mux{
 		middlewares: middlewares,
@@ -194,87 +1000,603 @@ func (mx *Mux) Group(fn func(r Router)) Router {
 	return im
 }
 
+// Prefix runs fn against an inline Router, like Group, on which every
+// pattern is registered with prefix literally prepended, so
+//
+//	mx.Prefix("/api/v1", func(r Router) {
+//		r.Get(`^/users/(?P<id>\d+)$`, getUser)
+//	})
+//
+// registers `^/api/v1(?:/users/(?P<id>\d+)$)` on mx. The route is anchored
+// at the start of the prefix and the inner pattern grouped after it, so each
+// of its alternatives stays under the prefix; the "^" they start with is
+// dropped.
+// Unlike Route no sub-Router is created: the routes are matched as ordinary
+// routes of mx. Calls to Prefix on the inline Router nest.
+func (mx *Mux) Prefix(prefix string, fn func(r Router)) {
+	im := &Mux{parent: mx, inline: true, prefix: prefix}
+	fn(im)
+}
+
+// prefixed returns pattern with the prefixes of mx and the inline muxes it is
+// derived from prepended; see Prefix.
+func (mx *Mux) prefixed(pattern string) string {
+	var prefix string
+	for m := mx; m != nil; m = m.parent {
+		prefix = m.prefix + prefix
+		if !m.inline {
+			break
+		}
+	}
+	if prefix == "" {
+		return pattern
+	}
+	return "^" + regexp.QuoteMeta(prefix) + "(?:" + trimAnchors(pattern) + ")"
+}
+
+// trimAnchors drops the "^" each top-level alternative of pattern starts
+// with, which after a prefix could never match.
+func trimAnchors(pattern string) string {
+	var b strings.Builder
+	depth, start := 0, true
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if start && c == '^' {
+			start = false
+			continue
+		}
+		start = false
+		switch {
+		case c == '\\' && strings.HasPrefix(pattern[i:], `\Q`):
+			// Quoted text runs to \E or the end.
+			end := strings.Index(pattern[i:], `\E`)
+			if end < 0 {
+				end = len(pattern) - i - 2
+			}
+			b.WriteString(pattern[i : i+end+2])
+			i += end + 1
+			continue
+		case c == '\\' && i+1 < len(pattern):
+			b.WriteString(pattern[i : i+2])
+			i++
+			continue
+		case c == '[':
+			// Copy the class whole; a "]" right after "[" or "[^" is
+			// literal.
+			j := i + 1
+			if j < len(pattern) && pattern[j] == '^' {
+				j++
+			}
+			if j < len(pattern) && pattern[j] == ']' {
+				j++
+			}
+			for j < len(pattern) && pattern[j] != ']' {
+				if pattern[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(pattern))
+			b.WriteString(pattern[i:j])
+			i = j - 1
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			start = true
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Try runs fn against the mux like Group and returns any registration panic
+// it raises, such as an invalid pattern, as an error instead, so routes built
+// in a loop can fail gracefully. Routes fn registered before the failure stay
+// registered. Other panics, such as a nil dereference in fn, are not
+// recovered.
+func (mx *Mux) Try(fn func(r Router)) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			// The router's own panics are all "regexrouter: " messages.
+			msg, ok := v.(string)
+			if !ok || !strings.HasPrefix(msg, "regexrouter: ") {
+				panic(v)
+			}
+			err = errors.New(msg)
+		}
+	}()
+	fn(mx)
+	return nil
+}
+
 // Route mounts a sub-Router along a `pattern“ string and returns it. The
 // returned Router stays live: routes registered on it after Route returns are
-// still matched. Registering routes inside fn and then calling Use on the
-// returned Router will panic (see Use); add middleware inside fn instead.
+// still matched, but Use on it wraps only routes registered after that call
+// (see Use); add middleware at the top of fn to cover all of them.
+//
+// Calling Route again with the same pattern runs fn against the sub-Router
+// already mounted there, so the child routes of every call are merged.
 func (mx *Mux) Route(pattern string, fn func(Router)) Router {
 	if fn == nil {
 		panic("regexrouter: Route requires a non-nil configuration func")
 	}
+	pattern = mx.prefixed(pattern)
+	// A repeated Route on an already-mounted pattern configures the existing
+	// sub-Router, so children from every call stay reachable.
+	if sr := mx.subrouter(pattern); sr != nil {
+		fn(sr)
+		checkSubroutes(pattern, sr)
+		mx.lastPattern = pattern
+		return sr
+	}
+
 	// Wire the parent (but leave inline false) so the sub-Router falls back to
 	// the parent's NotFound/MethodNotAllowed handlers when it has none of its
 	// own. inline stays false so the sub-Router keeps its own route table and
 	// its middleware is not re-chained through the parent (parent middleware
 	// already wraps the entry point registered by HandleFunc below).
 	sr := &Mux{parent: mx}
+	if c := mx.root().routes.cache; c != nil {
+		sr.routes.cache = newMatchCache(c.size)
+	}
+	sr.routes.indexed = mx.root().routes.indexed
 	fn(sr)
+	checkSubroutes(pattern, sr)
 
+	mx.method(methodAll, pattern, mx.compile(pattern), mountEntry(pattern, sr))
+	t := mx.table()
+	t.mu.Lock()
+	t.find(pattern, mx.condition()).subrouter = sr
+	t.mu.Unlock()
+	return sr
+}
+
+// RoutePrefix mounts a sub-Router like Route on the literal path prefix,
+// matching both the bare prefix and paths below it: for prefix "/route1" it
+// mounts on `^/route1(?:/(?P<subroute>.*))?$`, so the sub-Router sees "" for
+// "/route1" (and "/route1/"), served by a `^$` route, and "foo" for
+// "/route1/foo".
+func (mx *Mux) RoutePrefix(prefix string, fn func(Router)) Router {
+	return mx.Route("^"+regexp.QuoteMeta(prefix)+"(?:/(?P<"+SubrouteParam+">.*))?$", fn)
+}
+
+// SubMux returns a new, standalone Mux whose routes are wrapped in a snapshot
+// of the middleware stack of mx (see Middlewares) as it stands now, and which
+// takes its options and fallback handlers from mx like a sub-Router does.
+// Unlike With and Group, its routes go into its own table rather than that of
+// mx, and are not served until it is mounted with Mount.
+func (mx *Mux) SubMux() *Mux {
+	sub := &Mux{parent: mx, middlewares: mx.Middlewares(), middlewareNames: mx.middlewareStackNames()}
+	if c := mx.root().routes.cache; c != nil {
+		sub.routes.cache = newMatchCache(c.size)
+	}
+	sub.routes.indexed = mx.root().routes.indexed
+	return sub
+}
+
+// Mount serves sub, typically a Mux from SubMux, under pattern, like a
+// sub-Router mounted by Route: sub matches against the text captured by the
+// SubrouteParam group. Since a Mux from SubMux already carries the middleware
+// of mx, the mount is not wrapped in mx's middleware again.
+func (mx *Mux) Mount(pattern string, sub *Mux) {
+	pattern = mx.prefixed(pattern)
+	checkSubroutes(pattern, sub)
+	mx.insertMethod(-1, methodAll, pattern, mx.compile(pattern), mountEntry(pattern, sub), nil)
+	t := mx.table()
+	t.mu.Lock()
+	t.find(pattern, mx.condition()).subrouter = sub
+	t.mu.Unlock()
+}
+
+// TestHandler returns a handler serving mx as if it were mounted under the
+// literal path prefix, for testing a module's Mux, with patterns relative to
+// its mount point, in isolation: requests for prefix+"x" reach mx with the
+// remainder "x", as in production, and other paths get a 404.
+//
+//	ts := httptest.NewServer(widgets.TestHandler("/api/widgets/"))
+func (mx *Mux) TestHandler(prefix string) http.Handler {
+	host := New()
+	host.Mount("^"+regexp.QuoteMeta(prefix)+"(?P<"+SubrouteParam+">.*)$", mx)
+	return host
+}
+
+// mountEntry returns the handler a sub-Router mounted on pattern is reached
+// through, which hands it the remainder of the path to match.
+func mountEntry(pattern string, sr *Mux) http.HandlerFunc {
+	hasSubroute := hasSubrouteGroup(pattern)
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The value captured by the "subroute" group (if present) is the path
+		// the sub-Router matches against; without it the sub-Router sees "".
+		// Only read the parameter when this pattern has the group: otherwise
+		// the context holds the remainder of an enclosing Route, which this
+		// pattern has already consumed.
+		requestPath := ""
+		if hasSubroute {
+			requestPath = URLParamFromCtx(r.Context(), SubrouteParam)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyRequestPath, requestPath))
+		sr.ServeHTTP(w, r)
+	}
+}
+
+// subrouter returns the sub-Router mounted by Route on pattern, or nil.
+func (mx *Mux) subrouter(pattern string) *Mux {
+	t := mx.table()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if rt := t.find(pattern, mx.condition()); rt != nil {
+		return rt.subrouter
+	}
+	return nil
+}
+
+// checkSubroutes panics if the sub-Router mounted on pattern has a route that
+// can never be reached.
+func checkSubroutes(pattern string, sr *Mux) {
 	// When the pattern has no "subroute" capture group, the sub-Router always
 	// matches against the empty remainder, so any sub-route that cannot match
 	// "" is unreachable. That is almost always a forgotten (?P<subroute>...)
 	// group, so fail loudly at registration instead of 404-ing at request time.
 	if !hasSubrouteGroup(pattern) {
 		for _, rt := range sr.routes.rts {
-			if !rt.regex.MatchString("") {
+			if !rt.matchString("") {
 				panic(fmt.Sprintf("regexrouter: Route pattern %q has no (?P<%s>...) capture group, "+
 					"so its sub-Router only matches the empty remainder, but sub-route %q cannot "+
-					"match it and is unreachable", pattern, SubrouteParam, rt.regex.String()))
+					"match it and is unreachable", pattern, SubrouteParam, rt.pattern))
 			}
 		}
 	}
-
-	mx.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		// The value captured by the "subroute" group (if present) is the path
-		// the sub-Router matches against; without it the sub-Router sees "".
-		requestPath := URLParamFromCtx(r.Context(), SubrouteParam)
-		r = r.WithContext(context.WithValue(r.Context(), ctxKeyRequestPath, requestPath))
-		sr.ServeHTTP(w, r)
-	})
-	return sr
 }
 
 func (mx *Mux) Handle(pattern string, handler http.Handler) {
-	mx.Method(methodAll, pattern, handler)
+	mx.Method(mx.handleMethod(), pattern, handler)
 }
 
 func (mx *Mux) HandleFunc(pattern string, handler http.HandlerFunc) {
-	mx.Method(methodAll, pattern, handler)
+	mx.Method(mx.handleMethod(), pattern, handler)
+}
+
+// handleMethod returns the method Handle and HandleFunc register under: all
+// methods, unless WithDefaultMethod narrowed it.
+func (mx *Mux) handleMethod() string {
+	if m := mx.root().defaultMethod; m != "" {
+		return m
+	}
+	return methodAll
 }
 
 func (mx *Mux) Method(method, pattern string, handler http.Handler) {
+	pattern = mx.prefixed(pattern)
+	mx.method(method, pattern, mx.compile(pattern), handler)
+}
+
+// compile compiles a route pattern, case-insensitively under
+// WithCaseInsensitive, panicking if it is invalid.
+func (mx *Mux) compile(pattern string) *regexp.Regexp {
+	root := mx.root()
+	expr := pattern
+	if root.caseInsensitive {
+		expr = "(?i)" + pattern
+	}
+	start := time.Now()
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		panic(fmt.Sprintf("regexrouter: invalid route pattern %q: %v", pattern, err))
+	}
+	if root.compileStats != nil {
+		d := time.Since(start)
+		root.compileMu.Lock()
+		root.compileStats[pattern] = d
+		root.compileMu.Unlock()
+		if !root.silenceLogs {
+			mx.log().Debug("compiled route pattern", "pattern", pattern, "duration", d)
+		}
+	}
+	return re
+}
+
+// CompileStats returns how long each pattern registered on the router took
+// to compile, keyed by pattern, if enabled with WithCompileStats; otherwise
+// it returns nil. On a sub-Router it reports the stats of its root. The map
+// is a copy.
+func (mx *Mux) CompileStats() map[string]time.Duration {
+	root := mx.root()
+	root.compileMu.Lock()
+	defer root.compileMu.Unlock()
+	return maps.Clone(root.compileStats)
+}
+
+// MethodRegexp adds a route like Method, taking an already compiled pattern,
+// which is useful when patterns are generated and cached elsewhere. Routes are
+// identified by re.String(), so registering another method on an equal
+// pattern, compiled or not, adds to the same route. re is used as given, even
+// under WithCaseInsensitive, unless it is registered under Prefix, which
+// compiles the prefixed pattern.
+func (mx *Mux) MethodRegexp(method string, re *regexp.Regexp, handler http.Handler) {
+	if pattern := mx.prefixed(re.String()); pattern != re.String() {
+		re = mx.compile(pattern)
+	}
+	mx.method(method, re.String(), re, handler)
+}
+
+// method registers handler for method on the route identified by pattern,
+// creating the route with the compiled re if it does not exist.
+func (mx *Mux) method(method, pattern string, re *regexp.Regexp, handler http.Handler) {
+	mx.insertMethod(-1, method, pattern, re, mx.chainHandler(handler), mx.namedMiddleware())
+}
+
+// InsertMethod adds a route like Method, but at position index in the route
+// table rather than after the routes registered so far, so it takes
+// precedence over the routes from index on: InsertMethod(0, ...) places a
+// specific route ahead of a broad one registered earlier. The index counts
+// every route in the table, including those added through With, Group and
+// When. If pattern is already registered, the handler is added to that route,
+// which keeps its position. InsertMethod panics if index is out of range.
+func (mx *Mux) InsertMethod(index int, method, pattern string, handler http.Handler) {
+	if index < 0 {
+		panic(fmt.Sprintf("regexrouter: InsertMethod index %d out of range", index))
+	}
+	pattern = mx.prefixed(pattern)
+	mx.insertMethod(index, method, pattern, mx.compile(pattern), mx.chainHandler(handler), mx.namedMiddleware())
+}
+
+// insertMethod registers handler, already wrapped in the middleware named by
+// middleware, for method on pattern, adding a new route at index, or at the
+// end if index is negative.
+func (mx *Mux) insertMethod(index int, method, pattern string, re *regexp.Regexp, handler http.Handler, middleware []string) {
 	// Normalize the method so registrations are case-insensitive and match the
 	// upper-case r.Method values used at dispatch time. The wildcard sentinel
 	// is upper-case-stable, so this is safe for it too.
 	if method != methodAll {
 		method = strings.ToUpper(method)
 	}
-	handler = mx.chainHandler(handler)
-	mx.hasRoutes = true
+	if err := checkCaptureNames(re); err != nil {
+		panic(fmt.Sprintf("regexrouter: invalid route pattern %q: %v", pattern, err))
+	}
+	if owner := mx.owner(); owner.parent == nil && owner.requireLeadingSlash &&
+		!strings.HasPrefix(strings.TrimPrefix(pattern, "^"), "/") {
+		panic(fmt.Sprintf("regexrouter: route pattern %q does not begin with \"/\"", pattern))
+	}
+	mx.lastPattern = pattern
 
-	for _, rr := range mx.routes.rts {
-		if rr.regex.String() == pattern {
-			rr.methodhandler[method] = handler
-			return
+	t := mx.table()
+	added := false
+	defer func() {
+		if added {
+			t.notify(RouteEvent{Kind: RouteAdded, Method: method, Pattern: pattern})
 		}
+	}()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rr := t.find(pattern, mx.condition()); rr != nil {
+		rr.methodhandler[method] = handler
+		rr.middleware[method] = middleware
+		t.cache.clear()
+		added = true
+		return
 	}
 
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		panic(fmt.Sprintf("regexrouter: invalid route pattern %q: %v", pattern, err))
+	var literal string
+	if t.indexed {
+		literal, re = splitLiteral(re)
 	}
 	r := route{
+		pattern:       pattern,
+		literal:       literal,
 		regex:         re,
 		methodhandler: map[string]http.Handler{method: handler},
+		middleware:    map[string][]string{method: middleware},
 		varNames:      captureNames(re),
+		cond:          mx.condition(),
+		specificity:   literalLength(pattern),
+	}
+
+	if index < 0 {
+		index = len(t.rts)
+	} else if index > len(t.rts) {
+		panic(fmt.Sprintf("regexrouter: InsertMethod index %d out of range [0, %d]", index, len(t.rts)))
+	}
+	t.insert(index, r)
+	added = true
+}
+
+// Name names the route most recently registered through mx, for example as a
+// readable metrics label; handlers and middleware read it with RouteName. The
+// name belongs to the route (pattern), so it is shared by all its methods:
+//
+//	m.Get(`^/users/(?P<id>[0-9]+)$`, getUser)
+//	m.Name("user")
+//
+// Name panics if no route has been registered through mx.
+func (mx *Mux) Name(name string) {
+	mx.updateLast("Name", func(rt *route) { rt.name = name })
+}
+
+// Meta attaches metadata, such as a description or tags for generated
+// documentation, to the route most recently registered through mx. It is
+// reported in RouteInfo.Meta by Routes and Walk. Meta panics if no route has
+// been registered through mx.
+func (mx *Mux) Meta(key string, value any) {
+	mx.updateLast("Meta", func(rt *route) {
+		if rt.meta == nil {
+			rt.meta = make(map[string]any)
+		}
+		rt.meta[key] = value
+	})
+}
+
+// updateLast applies fn to the route most recently registered through mx,
+// panicking with a message naming caller if there is none.
+func (mx *Mux) updateLast(caller string, fn func(*route)) {
+	t := mx.table()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rt := t.find(mx.lastPattern, mx.condition())
+	if mx.lastPattern == "" || rt == nil {
+		panic(fmt.Sprintf("regexrouter: %s called before any route was registered", caller))
+	}
+	fn(rt)
+}
+
+// MatchN reports which route of the table of mx would serve method and path,
+// as its position in matching order (see Routes), or -1 if none would, and
+// how many patterns were run to decide, for tuning route order: in the
+// default first-match mode a route at index i costs i+1 pattern runs, so
+// frequently requested routes belong near the front. The path is matched as
+// given, without WithNormalizer, against a request carrying only the method
+// and path, so routes added with When may not match as they would for a real
+// request. Sub-Router mounts count as single routes. A result served from the
+// match cache (see WithMatchCache) reports 0 patterns run.
+func (mx *Mux) MatchN(method, path string) (index int, scanned int) {
+	req := &http.Request{Method: method, URL: &url.URL{Path: path}, Header: make(http.Header)}
+	m := mx.table().match(req, method, path, mx.root().mostSpecific, time.Time{})
+	if m.handler == nil {
+		return -1, m.scanned
+	}
+	return m.index, m.scanned
+}
+
+// HandlerFor returns the handler registered on mx for method and pattern, as
+// stored: already wrapped in the middleware that applied at registration. It
+// lets tests call a route's full chain directly, without routing. Use "*" as
+// the method for a Handle/HandleFunc registration. Inside Prefix, pattern is
+// given as registered there, without the prefix. Parameters are not set, as
+// nothing has been matched.
+func (mx *Mux) HandlerFor(method, pattern string) (http.Handler, bool) {
+	pattern = mx.prefixed(pattern)
+	if method != methodAll {
+		method = strings.ToUpper(method)
+	}
+	t := mx.table()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rt := t.find(pattern, mx.condition())
+	if rt == nil {
+		return nil, false
+	}
+	h, ok := rt.methodhandler[method]
+	return h, ok
+}
+
+// maxDispatchDepth bounds how many Dispatch calls may nest within a request.
+const maxDispatchDepth = 8
+
+// Dispatch serves r again through mx as if it had been made with method, for
+// handlers that re-dispatch a request internally, such as to tunnel a method
+// after authentication. Called on the mux that served the route, including a
+// sub-Router, it matches the same path that mux matched. Dispatch calls nest
+// at most 8 deep, so a route dispatching to itself gets the error handler (see
+// WithErrorHandler) rather than recursing forever.
+func (mx *Mux) Dispatch(w http.ResponseWriter, r *http.Request, method string) {
+	depth, _ := r.Context().Value(ctxKeyDispatchDepth).(int)
+	if depth >= maxDispatchDepth {
+		mx.handleError(w, r, fmt.Errorf("regexrouter: Dispatch nested more than %d deep", maxDispatchDepth))
+		return
+	}
+	ctx := context.WithValue(r.Context(), ctxKeyDispatchDepth, depth+1)
+
+	owner := mx.owner()
+	pattern := ""
+	if owner.parent == nil {
+		// A top-level mux matches the whole path, not the remainder of the
+		// sub-Router the caller may be running in.
+		ctx = context.WithValue(ctx, ctxKeyRequestPath, nil)
+	} else if i := strings.LastIndex(r.Pattern, routePatternSeparator); i >= 0 {
+		// Keep the patterns of the routes that led to this sub-Router.
+		pattern = r.Pattern[:i]
+	}
+
+	// Drop the params of the caller's route, so the target route sees only
+	// its own and those of the routes enclosing owner.
+	p := paramsFromCtx(ctx)
+	for p != nil && (owner.parent == nil || p.table == &owner.routes) {
+		p = p.parent
+	}
+	ctx = context.WithValue(ctx, ctxKeyParams, p)
+
+	r = r.Clone(ctx)
+	r.Method = strings.ToUpper(method)
+	r.Pattern = pattern
+	owner.ServeHTTP(w, r)
+}
+
+// Len returns the number of routes registered on mx, counting each sub-Router
+// mounted by Route as its own routes rather than as one. For an inline mux it
+// counts the table of the mux it is inlined into.
+func (mx *Mux) Len() int {
+	t := mx.table()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n := 0
+	for _, rt := range t.rts {
+		if rt.subrouter != nil {
+			n += rt.subrouter.Len()
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// Remove deregisters the handler for method on pattern, removing the route
+// entirely once it has no handlers left, and reports whether anything was
+// removed. Use "*" as the method to remove a Handle/HandleFunc registration.
+// It is safe to call while the mux is serving requests.
+func (mx *Mux) Remove(method, pattern string) bool {
+	pattern = mx.prefixed(pattern)
+	if method != methodAll {
+		method = strings.ToUpper(method)
+	}
+
+	t := mx.table()
+	cond := mx.condition()
+	removed := false
+	defer func() {
+		if removed {
+			t.notify(RouteEvent{Kind: RouteRemoved, Method: method, Pattern: pattern})
+		}
+	}()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, rt := range t.rts {
+		if rt.pattern != pattern || rt.cond != cond {
+			continue
+		}
+		if _, ok := rt.methodhandler[method]; !ok {
+			return false
+		}
+		delete(rt.methodhandler, method)
+		delete(rt.middleware, method)
+		t.cache.clear()
+		if len(rt.methodhandler) == 0 {
+			t.rts = slices.Delete(t.rts, i, i+1)
+			t.trie = nil
+		}
+		removed = true
+		return true
 	}
+	return false
+}
+
+// owner returns the mux whose route table mx registers into: mx itself, or
+// for an inline mux the mux it is inlined into.
+func (mx *Mux) owner() *Mux {
+	for mx.inline && mx.parent != nil {
+		mx = mx.parent
+	}
+	return mx
+}
 
+// table returns the route table this mux registers into: its own, or for an
+// inline mux (With/Group) the table of the mux it is inlined into.
+func (mx *Mux) table() *routes {
 	if mx.parent != nil && mx.inline {
-		mx.parent.routes.append(r)
-		mx.parent.hasRoutes = true
-	} else {
-		mx.routes.append(r)
+		return mx.parent.table()
 	}
+	return &mx.routes
 }
 
 // hasSubrouteGroup reports whether pattern contains a capture group named
@@ -293,6 +1615,31 @@ func hasSubrouteGroup(pattern string) bool {
 	return false
 }
 
+// literalLength returns the number of characters pattern must match
+// literally, ignoring literals under repetition or alternation, as a measure
+// of its specificity. pattern is assumed valid.
+func literalLength(pattern string) int {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	var count func(*syntax.Regexp) int
+	count = func(re *syntax.Regexp) int {
+		switch re.Op {
+		case syntax.OpLiteral:
+			return len(re.Rune)
+		case syntax.OpConcat, syntax.OpCapture:
+			n := 0
+			for _, sub := range re.Sub {
+				n += count(sub)
+			}
+			return n
+		}
+		return 0
+	}
+	return count(re.Simplify())
+}
+
 // captureNames returns the names of a compiled pattern's capture groups (in
 // order, excluding the whole-match group at index 0). Unnamed groups yield "".
 func captureNames(re *regexp.Regexp) []string {
@@ -319,6 +1666,41 @@ func (mx *Mux) Get(pattern string, handler http.HandlerFunc) {
 	mx.MethodFunc(http.MethodGet, pattern, handler)
 }
 
+// GetWithParams adds a GET route whose handler also sees values as request
+// parameters, readable with URLParam, so a literal route such as
+// `^/legacy-home$` can share a handler with a dynamic one by supplying the
+// value the dynamic pattern would capture. A captured parameter of the same
+// name takes precedence. The values are copied.
+func (mx *Mux) GetWithParams(pattern string, values map[string]string, handler http.HandlerFunc) {
+	values = maps.Clone(values)
+	mx.Get(pattern, func(w http.ResponseWriter, r *http.Request) {
+		// Extend the matched route's params in place of adding a layer, so
+		// the accessors describing the route still find it innermost.
+		p := &params{}
+		if cur := paramsFromCtx(r.Context()); cur != nil {
+			*p = *cur
+		}
+		p.extra = maps.Clone(p.extra)
+		for name, value := range values {
+			if _, ok := p.get(name); !ok {
+				if p.extra == nil {
+					p.extra = make(map[string]string, len(values))
+				}
+				p.extra[name] = value
+			}
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), ctxKeyParams, p)))
+	})
+}
+
+// GetAliases adds a GET route for each of patterns, all served by handler,
+// for endpoints reachable under legacy aliases.
+func (mx *Mux) GetAliases(patterns []string, handler http.HandlerFunc) {
+	for _, pattern := range patterns {
+		mx.Get(pattern, handler)
+	}
+}
+
 func (mx *Mux) Head(pattern string, handler http.HandlerFunc) {
 	mx.MethodFunc(http.MethodHead, pattern, handler)
 }
@@ -343,6 +1725,331 @@ func (mx *Mux) Trace(pattern string, handler http.HandlerFunc) {
 	mx.MethodFunc(http.MethodTrace, pattern, handler)
 }
 
+// Segments adds a route, like HandleFunc, matching any path of at least n
+// non-empty segments, such as "/a/b/c" for n of 2, without writing the
+// pattern. The segments are the route's first n captures, readable with
+// URLParamIndex(r, 0) through URLParamIndex(r, n-1), and the rest of the path,
+// "" or starting with "/", is the RestParam parameter. Segments panics if n is
+// less than 1.
+func (mx *Mux) Segments(n int, handler http.HandlerFunc) {
+	if n < 1 {
+		panic(fmt.Sprintf("regexrouter: Segments count %d is less than 1", n))
+	}
+	pattern := "^" + strings.Repeat("/([^/]+)", n) + "(?P<" + RestParam + ">/.*)?$"
+	mx.HandleFunc(pattern, handler)
+}
+
+// MethodRecover adds a route like MethodFunc whose handler panics are
+// recovered and passed to onPanic, which writes the response, for routes that
+// need their own error payload. Panics with http.ErrAbortHandler are not
+// recovered, so aborting a response still works. Middleware runs outside the
+// recovery.
+func (mx *Mux) MethodRecover(method, pattern string, onPanic func(http.ResponseWriter, *http.Request, any), handler http.HandlerFunc) {
+	mx.MethodFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				onPanic(w, r, v)
+			}
+		}()
+		handler(w, r)
+	})
+}
+
+// MethodLimit adds a route like MethodFunc that serves at most max requests
+// at a time, independently of any other route; requests beyond that get a 429
+// Too Many Requests rather than waiting. Middleware runs outside the limit.
+// MethodLimit panics if max is less than 1.
+func (mx *Mux) MethodLimit(method, pattern string, max int, handler http.HandlerFunc) {
+	if max < 1 {
+		panic(fmt.Sprintf("regexrouter: MethodLimit max %d for %q is less than 1", max, pattern))
+	}
+	sem := make(chan struct{}, max)
+	mx.MethodFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			handler(w, r)
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("too many requests"))
+		}
+	})
+}
+
+// MethodWriteDeadline adds a route like MethodFunc that, before calling
+// handler, sets the deadline for writing the response to d from now with
+// http.ResponseController, overriding the server's WriteTimeout for this
+// route, for example to cut off slow clients of a large download sooner.
+// Writes after the deadline fail. Response writers that cannot set deadlines,
+// even through the Unwrap chain of middleware writers, are served without
+// one.
+func (mx *Mux) MethodWriteDeadline(method, pattern string, d time.Duration, handler http.HandlerFunc) {
+	mx.MethodFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d))
+		handler(w, r)
+	})
+}
+
+// MethodCanary adds a route like MethodFunc served, on each request, by one
+// of handlers picked at random in proportion to its Weight, for example to
+// send a tenth of the traffic to a canary:
+//
+//	m.MethodCanary(http.MethodGet, `^/search$`, []regexrouter.WeightedHandler{
+//		{Weight: 90, Handler: search},
+//		{Weight: 10, Handler: searchCanary},
+//	})
+//
+// See WithCanarySeed for reproducible choices. MethodCanary panics if a weight
+// is negative or the weights sum to zero.
+func (mx *Mux) MethodCanary(method, pattern string, handlers []WeightedHandler) {
+	total := 0
+	for _, h := range handlers {
+		if h.Weight < 0 {
+			panic(fmt.Sprintf("regexrouter: MethodCanary weight %d for %q is negative", h.Weight, pattern))
+		}
+		total += h.Weight
+	}
+	if total == 0 {
+		panic(fmt.Sprintf("regexrouter: MethodCanary weights for %q sum to zero", pattern))
+	}
+	handlers = slices.Clone(handlers)
+	mx.MethodFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		n := mx.canaryIntN(total)
+		for _, h := range handlers {
+			if n < h.Weight {
+				h.Handler(w, r)
+				return
+			}
+			n -= h.Weight
+		}
+	})
+}
+
+// canaryIntN returns a random int in [0, n) for MethodCanary, from the source
+// set by WithCanarySeed if any.
+func (mx *Mux) canaryIntN(n int) int {
+	root := mx.root()
+	if root.canaryRand == nil {
+		return rand.IntN(n)
+	}
+	root.canaryMu.Lock()
+	defer root.canaryMu.Unlock()
+	return root.canaryRand.IntN(n)
+}
+
+// MethodDynamic adds a route like Method whose handler is chosen per request
+// by factory, from the request's named parameters as returned by URLParams,
+// for data-driven dispatch such as a plugin per captured name:
+//
+//	m.MethodDynamic(http.MethodGet, `^/plugins/(?P<name>\w+)$`, func(params map[string]string) http.Handler {
+//		return plugins[params["name"]]
+//	})
+//
+// Nothing is cached: factory is called on every request, so it should be
+// cheap. If it returns nil the request gets the NotFound handler.
+func (mx *Mux) MethodDynamic(method, pattern string, factory func(params map[string]string) http.Handler) {
+	mx.MethodFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		h := factory(URLParams(r))
+		if h == nil {
+			mx.handleNotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// MethodE adds a route for `pattern` that matches the `method` HTTP method and
+// is served by an error-returning handler. A non-nil error is handed to the
+// error handler (see WithErrorHandler), which writes the response.
+func (mx *Mux) MethodE(method, pattern string, handler HandlerFuncE) {
+	mx.MethodFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r); err != nil {
+			mx.handleError(w, r, err)
+		}
+	})
+}
+
+func (mx *Mux) GetE(pattern string, handler HandlerFuncE) {
+	mx.MethodE(http.MethodGet, pattern, handler)
+}
+
+// Resource adds a route for `pattern` that supports exactly the HTTP methods
+// in methods, each served by its handler. OPTIONS is answered automatically
+// with a 204 listing the methods in an Allow header, and HEAD by the GET
+// handler (without its body), unless methods has handlers for them. Any other
+// method gets a 405 with the same Allow header rather than falling through to
+// later, overlapping patterns.
+func (mx *Mux) Resource(pattern string, methods map[string]http.HandlerFunc) {
+	handlers := make(map[string]http.HandlerFunc, len(methods))
+	for method, h := range methods {
+		handlers[strings.ToUpper(method)] = h
+	}
+	allow := strings.Join(slices.Sorted(maps.Keys(handlers)), ", ")
+
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := handlers[r.Method]; ok {
+			h(w, r)
+			return
+		}
+		if h, ok := handlers[http.MethodGet]; ok && r.Method == http.MethodHead {
+			// HEAD requests to an all-methods route already have their body
+			// dropped; see ServeHTTP.
+			h(w, r)
+			return
+		}
+		if mx.root().hideMethodNotAllowed && r.Method != http.MethodOptions {
+			mx.handleNotFound(w, r)
+			return
+		}
+		w.Header().Set("Allow", allow)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		mx.handleMethodNotAllowed(w, r)
+	}
+	mx.MethodFunc(methodAll, pattern, serve)
+	// Registered explicitly, so that GlobalOptions leaves the route's own
+	// answer in place.
+	mx.MethodFunc(http.MethodOptions, pattern, serve)
+}
+
+// MountFS serves the files of fsys (for example an embed.FS) with
+// http.FileServerFS on GET and HEAD requests matching `pattern`. The pattern
+// must have a SubrouteParam group, whose match is the file path to serve:
+//
+//	m.MountFS(`^/static/(?P<subroute>.*)$`, assets) // GET /static/app.css serves app.css
+//
+// Missing files get the file server's own 404 response, not the router's
+// NotFound handler, since the route has already matched.
+func (mx *Mux) MountFS(pattern string, fsys fs.FS) {
+	if !hasSubrouteGroup(pattern) {
+		panic(fmt.Sprintf("regexrouter: MountFS pattern %q has no (?P<%s>...) capture group", pattern, SubrouteParam))
+	}
+	fileServer := http.FileServerFS(fsys)
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + URLParam(r, SubrouteParam)
+		r2.URL.RawPath = ""
+		fileServer.ServeHTTP(w, r2)
+	}
+	mx.Get(pattern, serve)
+	mx.Head(pattern, serve)
+}
+
+// Redirect adds a route for `pattern` on all HTTP methods that redirects to
+// target with the given status code, which must be one of 301, 302, 303, 307
+// or 308. Target is expanded as by regexp.Regexp.Expand: `$1` or `${name}`
+// (or `$name`) is replaced by the value of the capture group, path-escaped
+// but for its slashes, and `$$` by a single "$". For example:
+//
+//	m.Redirect(`^/old/(?P<id>\d+)$`, "/new/${id}", http.StatusPermanentRedirect)
+func (mx *Mux) Redirect(pattern, target string, code int) {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		panic(fmt.Sprintf("regexrouter: Redirect code %d is not a redirect status", code))
+	}
+	var re *regexp.Regexp
+	mx.MethodFunc(methodAll, pattern, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, expandTarget(r, re, target), code)
+	})
+	// Registration has validated pattern, and a Prefix adds no groups, so
+	// the route's captures line up with re's.
+	re = regexp.MustCompile(pattern)
+}
+
+// expandTarget expands target with the captures of re, the pattern of the
+// route that matched r; see Redirect.
+func expandTarget(r *http.Request, re *regexp.Regexp, target string) string {
+	var matches []string
+	if p := paramsFromCtx(r.Context()); p != nil {
+		matches = p.matches
+	}
+	// Expand takes the values as offsets into one source string, so lay the
+	// escaped values out in one.
+	var src strings.Builder
+	offsets := make([]int, 0, 2*len(matches))
+	for _, m := range matches {
+		segments := strings.Split(m, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		v := strings.Join(segments, "/")
+		offsets = append(offsets, src.Len(), src.Len()+len(v))
+		src.WriteString(v)
+	}
+	return string(re.ExpandString(nil, target, src.String(), offsets))
+}
+
+// GlobalOptions sets a handler that answers every OPTIONS request, including
+// ones for paths no route matches, such as CORS preflight requests. The path
+// is matched first, so that routes registered for OPTIONS explicitly (with
+// Options or Method) and Resource routes still win; all-methods routes
+// (Handle, HandleFunc) do not. Sub-Routers use the handler set on the root
+// mux.
+func (mx *Mux) GlobalOptions(handler http.HandlerFunc) {
+	mx.globalOptions = handler
+}
+
+// After registers fn to run once the handler of each request matched by mx
+// has returned, for example to log its final state. Hooks run in registration
+// order, also when the handler panics (before the panic continues up the
+// stack), but not for requests that end in a 404 or 405. The request passed
+// is the one the handler received.
+func (mx *Mux) After(fn func(*http.Request)) {
+	mx.afterHooks = append(mx.afterHooks, fn)
+}
+
+// UsePostMatch adds middlewares that wrap the handler of each request matched
+// by mx at dispatch time, once its parameters, route name (see RouteName),
+// metadata (see RouteMeta) and r.Pattern are set, so they can decide by route,
+// for example to reject routes tagged with a scope the caller lacks. Unlike
+// Use, they apply to routes registered before the call too. They run outside
+// the Use middleware, which is part of the route's handler, so a request they
+// reject never reaches it. A sub-Router mount counts as a single route: call
+// UsePostMatch on the sub-Router for its routes.
+func (mx *Mux) UsePostMatch(middlewares ...func(http.Handler) http.Handler) {
+	for _, mw := range middlewares {
+		mx.postMatch = append(mx.postMatch, func(next http.Handler, _ string) http.Handler {
+			return mw(next)
+		})
+	}
+}
+
+// Around adds fn, like a middleware passed to UsePostMatch, to wrap the
+// handler of each request matched by mx at dispatch time, also giving it the
+// pattern of the matched route, as registered on mx, for example to label
+// metrics by route:
+//
+//	m.Around(func(next http.Handler, pattern string) http.Handler {
+//		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//			defer observe(pattern, time.Now())
+//			next.ServeHTTP(w, r)
+//		})
+//	})
+//
+// fn runs on every matched request, so it should be cheap.
+func (mx *Mux) Around(fn func(next http.Handler, pattern string) http.Handler) {
+	mx.postMatch = append(mx.postMatch, fn)
+}
+
+// Fallback sets h as the catch-all for requests no route matches, like
+// NotFound, for example to forward them to an upstream proxy. Misses inside
+// sub-Routers reach it too, unless they set a NotFound handler of their own.
+// It sees the request as received, with the full original path, and can
+// route it again; NotFoundInfo describes the miss.
+func (mx *Mux) Fallback(h http.Handler) {
+	mx.notFoundHandler = h.ServeHTTP
+}
+
 func (mx *Mux) NotFound(handler http.HandlerFunc) {
 	mx.notFoundHandler = handler
 }
@@ -351,58 +2058,245 @@ func (mx *Mux) MethodNotAllowed(handler http.HandlerFunc) {
 	mx.methodNotAllowedHandler = handler
 }
 
+// clientGone reports whether the context of r was canceled by the server for
+// a client that has disconnected; see WithCanceledStatus.
+func clientGone(r *http.Request) bool {
+	ctx := r.Context()
+	return ctx.Value(http.ServerContextKey) != nil && context.Cause(ctx) == context.Canceled
+}
+
 func (mx *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	if requestpath, ok := r.Context().Value(ctxKeyRequestPath).(string); ok {
-		path = requestpath
+	if mx.rejectControlChars && hasControlChar(r.URL.Path) ||
+		mx.rejectBodyOnGet && r.ContentLength != 0 && bodyless(r.Method) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+		return
 	}
-
-	// pathMatched tracks whether any route matched the path but not the
-	// method, so we can distinguish 405 (Method Not Allowed) from 404 (Not
-	// Found) only after considering every overlapping pattern.
-	pathMatched := false
-
-	for _, route := range mx.routes.rts {
-		matches := route.regex.FindStringSubmatch(path)
-		if len(matches) <= 0 {
-			continue
+	if mx.parent == nil && r.Context().Err() != nil {
+		if !clientGone(r) {
+			code := cmp.Or(mx.canceledStatus, http.StatusServiceUnavailable)
+			w.WriteHeader(code)
+			w.Write([]byte(strings.ToLower(http.StatusText(code))))
 		}
-		handler, ok := route.methodhandler[r.Method]
-		if !ok {
-			handler, ok = route.methodhandler[methodAll]
+		return
+	}
+	path := mx.matchPath(r)
+
+	var deadline time.Time
+	if d := mx.root().matchTimeout; d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	timing := mx.serverTiming && mx.parent == nil
+	var start time.Time
+	if timing {
+		start = time.Now()
+	}
+	m := mx.routes.match(r, r.Method, path, mx.root().mostSpecific, deadline)
+	if timing {
+		ms := float64(time.Since(start).Nanoseconds()) / 1e6
+		w.Header().Add("Server-Timing", "route;dur="+strconv.FormatFloat(ms, 'f', 3, 64))
+	}
+	if m.timedOut {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("service unavailable"))
+		return
+	}
+	if r.Method == http.MethodOptions && (m.handler == nil || m.viaAny && m.route.subrouter == nil) {
+		// Leave a sub-Router mount to the sub-Router, which may have an
+		// explicit OPTIONS route of its own.
+		if h := mx.root().globalOptions; h != nil {
+			h(w, r)
+			return
 		}
-		if !ok {
-			// This pattern matched the path but has no handler for the
-			// method. Keep scanning: another overlapping pattern may.
-			pathMatched = true
-			continue
+	}
+	if m.handler != nil {
+		route := m.route
+		root := mx.root()
+		if v, _ := r.Context().Value(ctxKeyVariant).(string); v != "" {
+			if h, ok := route.methodhandler[strings.ToUpper(r.Method+variantSeparator+v)]; ok {
+				m.handler = h
+			}
 		}
-
-		ctx := r.Context()
-		for i, match := range matches[1:] {
-			if i > len(route.varNames)-1 || route.varNames[i] == "" {
-				// Unnamed capture group: not exposed as a parameter.
-				continue
+		// A top-level route without captures or a name, matched for its
+		// method, adds nothing to the request unless its pattern is recorded,
+		// so the request is then passed on as is, sparing the context
+		// allocations. A sub-route always gets its own params, or it would
+		// report those of its mount.
+		if !root.noRecordPattern || len(m.matches) > 1 || m.viaAny || route.name != "" || route.meta != nil || root.captureQuery ||
+			paramsFromCtx(r.Context()) != nil {
+			ctx := r.Context()
+			p := &params{
+				names:   route.varNames,
+				matches: m.matches,
+				viaAny:  m.viaAny,
+				name:    route.name,
+				meta:    route.meta,
+				table:   mx.table(),
+				parent:  paramsFromCtx(ctx),
+			}
+			if _, nested := ctx.Value(ctxKeyRequestPath).(string); !nested && root.captureQuery {
+				// Enclose the route's params, whose captures override them.
+				query := make(map[string]string)
+				for name, values := range r.URL.Query() {
+					query[name] = values[0]
+				}
+				p.parent = &params{extra: query, parent: p.parent}
+			}
+			ctx = context.WithValue(ctx, ctxKeyParams, p)
+			if !root.noRecordPattern {
+				if r.Pattern == "" {
+					r.Pattern = route.pattern
+				} else {
+					r.Pattern = r.Pattern + routePatternSeparator + route.pattern
+				}
+			}
+			if !root.noPathValues && slices.ContainsFunc(route.varNames, func(name string) bool { return name != "" }) {
+				// The path values of a shallow copy are shared with r, whose
+				// own would then change under the caller, say a sub-Router's
+				// mount.
+				r = r.Clone(ctx)
+				for i, name := range route.varNames {
+					if name != "" && i+1 < len(m.matches) {
+						r.SetPathValue(name, m.matches[i+1])
+					}
+				}
+			} else {
+				r = r.WithContext(ctx)
 			}
-			ctx = context.WithValue(ctx, paramKey(route.varNames[i]), match)
 		}
-		if r.Pattern == "" {
-			r.Pattern = route.regex.String()
-		} else {
-			r.Pattern = r.Pattern + routePatternSeparator + route.regex.String()
+		if r.Method == http.MethodHead && m.viaAny {
+			// An all-methods handler cannot be expected to know it must not
+			// write a body for HEAD, so drop the body on its behalf.
+			w = headResponseWriter{w}
 		}
-		handler.ServeHTTP(w, r.WithContext(ctx))
+		if len(mx.afterHooks) > 0 {
+			defer func() {
+				for _, fn := range mx.afterHooks {
+					fn(r)
+				}
+			}()
+		}
+		handler := m.handler
+		for i := len(mx.postMatch) - 1; i >= 0; i-- {
+			handler = mx.postMatch[i](handler, route.pattern)
+		}
+		handler.ServeHTTP(w, r)
 		return
 	}
 
-	if pathMatched {
+	if m.pathMatched && r.Method == http.MethodTrace && mx.root().traceEcho {
+		traceEcho(w, r)
+		return
+	}
+	if m.pathMatched && !mx.root().hideMethodNotAllowed {
+		// Computed from this mux's own routes, so a sub-Router reports the
+		// methods of its sub-route rather than of the parent's mount.
+		w.Header().Set("Allow", strings.Join(m.allowed, ", "))
 		mx.handleMethodNotAllowed(w, r)
-		mx.log().Debug("method not allowed", "method", r.Method, "path", path)
+		if !mx.root().silenceLogs {
+			mx.log().Debug("method not allowed", "method", r.Method, "path", path)
+		}
 		return
 	}
+	if code := mx.root().misdirectedStatus; code != 0 && m.misdirected {
+		w.WriteHeader(code)
+		w.Write([]byte(strings.ToLower(http.StatusText(code))))
+		return
+	}
+	_, nested := r.Context().Value(ctxKeyRequestPath).(string)
+	ctx := context.WithValue(r.Context(), ctxKeyNotFound, notFoundInfo{path, nested})
+	if nested {
+		// Drop the sub-Router remainder, so a not-found handler that routes
+		// the request again, such as another Mux, sees the whole path.
+		ctx = context.WithValue(ctx, ctxKeyRequestPath, nil)
+	}
+	r = r.WithContext(ctx)
+	if fn := mx.root().onUnmatched; fn != nil {
+		fn(r)
+	}
 	mx.handleNotFound(w, r)
 }
 
+// bodyless reports whether requests with method are not expected to carry a
+// body; see WithRejectBodyOnGet.
+func bodyless(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodDelete
+}
+
+// hasControlChar reports whether s contains an ASCII control character.
+func hasControlChar(s string) bool {
+	return strings.ContainsFunc(s, func(c rune) bool {
+		return c < 0x20 || c == 0x7f
+	})
+}
+
+// matchPath returns the string this mux matches its patterns against: the
+// remainder set by Route for a sub-Router, otherwise the request path (or the
+// full request URI under WithMatchRawURI) passed through any WithNormalizer
+// function.
+func (mx *Mux) matchPath(r *http.Request) string {
+	if requestpath, ok := r.Context().Value(ctxKeyRequestPath).(string); ok {
+		return requestpath
+	}
+	path := r.URL.Path
+	if mx.matchRawURI {
+		path = r.RequestURI
+		if path == "" {
+			path = r.URL.RequestURI()
+		}
+	}
+	if mx.matchCleaned {
+		path = cleanPath(path)
+	}
+	if mx.normalizer != nil {
+		path = mx.normalizer(path)
+	}
+	return path
+}
+
+// traceEcho writes r's request line and headers, less credentials, as the
+// message/http response to a TRACE request.
+func traceEcho(w http.ResponseWriter, r *http.Request) {
+	r = r.Clone(r.Context())
+	for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+		r.Header.Del(name)
+	}
+	dump, err := httputil.DumpRequest(r, false)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("internal server error"))
+		return
+	}
+	w.Header().Set("Content-Type", "message/http")
+	w.Write(dump)
+}
+
+// cleanPath returns p cleaned by path.Clean, keeping a trailing slash and
+// leaving any query string, as under WithMatchRawURI, untouched.
+func cleanPath(p string) string {
+	p, query, hasQuery := strings.Cut(p, "?")
+	if p == "" {
+		p = "/"
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	if hasQuery {
+		cleaned += "?" + query
+	}
+	return cleaned
+}
+
+// root returns the top-level mux that mx is mounted or inlined under, whose
+// options govern matching.
+func (mx *Mux) root() *Mux {
+	for mx.parent != nil {
+		mx = mx.parent
+	}
+	return mx
+}
+
 // log resolves the logger for this mux: its own if set, otherwise the parent's,
 // falling back to a no-op. This mirrors the NotFound/MethodNotAllowed fallback
 // so sub-Routers inherit the logger configured on the root.
@@ -416,6 +2310,31 @@ func (mx *Mux) log() Logger {
 	return noopLogger{}
 }
 
+// Wrap returns mx wrapped in middlewares, outermost first. Unlike Use, whose
+// middleware wraps matched handlers only, these run for every request, including
+// ones that end in a 404 or 405, which suits request IDs and access logging.
+// Serve the returned handler in place of mx.
+func (mx *Mux) Wrap(middlewares ...Middleware) http.Handler {
+	var h http.Handler = mx
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Middlewares returns the middleware stack applied to routes registered on mx,
+// outermost first: for an inline mux (With/Group) that is the parent's stack
+// followed by its own. A sub-Router mounted by Route reports only its own
+// stack, since its parent's middleware wraps the mount point instead. The
+// returned slice is a copy.
+func (mx *Mux) Middlewares() Middlewares {
+	var mws Middlewares
+	if mx.parent != nil && mx.inline {
+		mws = mx.parent.Middlewares()
+	}
+	return append(mws, mx.middlewares...)
+}
+
 func (mx *Mux) chainHandler(handler http.Handler) http.Handler {
 	for i := len(mx.middlewares) - 1; i >= 0; i-- {
 		handler = mx.middlewares[i](handler)
@@ -426,6 +2345,39 @@ func (mx *Mux) chainHandler(handler http.Handler) http.Handler {
 	return handler
 }
 
+// packageDefaults holds the handlers set by SetDefaultNotFoundHandler and
+// SetDefaultMethodNotAllowedHandler, used when neither a mux nor any of its
+// parents has its own.
+var packageDefaults struct {
+	mu               sync.RWMutex
+	notFound         http.HandlerFunc
+	methodNotAllowed http.HandlerFunc
+}
+
+// SetDefaultNotFoundHandler sets the package-wide handler for requests that
+// match no route, used by every Mux that (with its parents) has no NotFound
+// handler of its own. Pass nil to restore the built-in 404. It is safe to call
+// concurrently with serving.
+func SetDefaultNotFoundHandler(h http.HandlerFunc) {
+	packageDefaults.mu.Lock()
+	defer packageDefaults.mu.Unlock()
+	packageDefaults.notFound = h
+}
+
+// SetDefaultMethodNotAllowedHandler is the method-not-allowed counterpart of
+// SetDefaultNotFoundHandler. Pass nil to restore the built-in 405.
+func SetDefaultMethodNotAllowedHandler(h http.HandlerFunc) {
+	packageDefaults.mu.Lock()
+	defer packageDefaults.mu.Unlock()
+	packageDefaults.methodNotAllowed = h
+}
+
+func packageHandler(h *http.HandlerFunc) http.HandlerFunc {
+	packageDefaults.mu.RLock()
+	defer packageDefaults.mu.RUnlock()
+	return *h
+}
+
 func (mx *Mux) handleNotFound(w http.ResponseWriter, r *http.Request) {
 	if mx.notFoundHandler != nil {
 		mx.notFoundHandler(w, r)
@@ -435,6 +2387,16 @@ func (mx *Mux) handleNotFound(w http.ResponseWriter, r *http.Request) {
 		mx.parent.handleNotFound(w, r)
 		return
 	}
+	packageNotFound(w, r)
+}
+
+// packageNotFound responds with the package-level NotFound handler (see
+// SetDefaultNotFoundHandler), or the built-in 404 if none is set.
+func packageNotFound(w http.ResponseWriter, r *http.Request) {
+	if h := packageHandler(&packageDefaults.notFound); h != nil {
+		h(w, r)
+		return
+	}
 	defaultNotFoundHandler(w, r)
 }
 
@@ -443,6 +2405,23 @@ func defaultNotFoundHandler(w http.ResponseWriter, _ *http.Request) {
 	w.Write([]byte("not found"))
 }
 
+func (mx *Mux) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if mx.errorHandler != nil {
+		mx.errorHandler(w, r, err)
+		return
+	}
+	if mx.parent != nil {
+		mx.parent.handleError(w, r, err)
+		return
+	}
+	defaultErrorHandler(w, r, err)
+}
+
+func defaultErrorHandler(w http.ResponseWriter, _ *http.Request, _ error) {
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte("internal server error"))
+}
+
 func (mx *Mux) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
 	if mx.methodNotAllowedHandler != nil {
 		mx.methodNotAllowedHandler(w, r)
@@ -452,6 +2431,16 @@ func (mx *Mux) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
 		mx.parent.handleMethodNotAllowed(w, r)
 		return
 	}
+	packageMethodNotAllowed(w, r)
+}
+
+// packageMethodNotAllowed serves the package-wide method-not-allowed handler
+// (see SetDefaultMethodNotAllowedHandler), or the built-in 405 if none is set.
+func packageMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	if h := packageHandler(&packageDefaults.methodNotAllowed); h != nil {
+		h(w, r)
+		return
+	}
 	defaultMethodNotAllowedHandler(w, r)
 }
 
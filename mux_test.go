@@ -2,12 +2,20 @@ package regexrouter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 type testCase struct {
@@ -378,6 +386,56 @@ func TestOCIDistRouting(t *testing.T) {
 	runTestCases(t, ts, testCases)
 }
 
+// TestRouteNamedGroupWithRemainder verifies a parent pattern capturing a
+// named group ahead of the sub-Router remainder exposes the named value to
+// the sub-Router, whose routes match the remainder alone and add their own
+// captures alongside it.
+func TestRouteNamedGroupWithRemainder(t *testing.T) {
+	m := New()
+	m.Route(`^/repos/(?P<name>[^/]+)/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^$`, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "repo %s", URLParam(r, "name"))
+		})
+		r.Get(`^tags/(?P<tag>[^/]+)$`, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "repo %s tag %s", URLParam(r, "name"), URLParam(r, "tag"))
+		})
+		r.Get(`^blobs/(.*)$`, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "repo %s blob %s %q", URLParam(r, "name"), URLParamIndex(r, 0), URLParam(r, SubrouteParam))
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "empty remainder",
+			path:           "/repos/app/",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "repo app",
+		}, {
+			name:           "named child capture",
+			path:           "/repos/app/tags/v1",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "repo app tag v1",
+		}, {
+			name:           "unnamed child capture",
+			path:           "/repos/app/blobs/sha256/abc",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `repo app blob sha256/abc "blobs/sha256/abc"`,
+		}, {
+			name:           "remainder does not rematch parent",
+			path:           "/repos/app/app/tags/v1",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+}
+
 func returnMWs(t *testing.T) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		v, ok := r.Context().Value("middlewares").([]string)
@@ -498,18 +556,43 @@ func TestURLParamNamespacing(t *testing.T) {
 	}})
 }
 
-// TestUseAfterRoutePanics verifies that registering middleware after a route
-// fails loudly instead of silently dropping the middleware.
-func TestUseAfterRoutePanics(t *testing.T) {
+// TestUseAfterRoute verifies Use applies to routes registered after it, and to
+// sub-Routers mounted after it, but not to routes registered before it.
+func TestUseAfterRoute(t *testing.T) {
 	m := New()
-	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {})
+	m.Get(`^/before$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(w.Header().Get("X-Mw")))
+	})
+	m.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Mw", "applied")
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.Route(`^/sub/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^after$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(w.Header().Get("X-Mw")))
+		})
+	})
 
-	defer func() {
-		if recover() == nil {
-			t.Fatal("expected Use() after a route registration to panic")
-		}
-	}()
-	m.Use(func(next http.Handler) http.Handler { return next })
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "route registered before Use is unwrapped",
+			path:           "/before",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "",
+		}, {
+			name:           "sub-Router mounted after Use is wrapped",
+			path:           "/sub/after",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "applied",
+		},
+	})
 }
 
 // TestRouteReturnsLiveRouter verifies Route returns a usable sub-Router (not
@@ -840,6 +923,26 @@ func TestWithLogger(t *testing.T) {
 	}
 }
 
+// TestWithSilenceInternalLogs verifies a silenced router sends nothing to
+// its logger, including from sub-Routers.
+func TestWithSilenceInternalLogs(t *testing.T) {
+	logger := &captureLogger{}
+	m := New(WithLogger(logger), WithSilenceInternalLogs())
+	m.Get(`^/x$`, func(w http.ResponseWriter, r *http.Request) {})
+	m.Route(`^/r/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^known$`, func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	testRequest(t, ts, http.MethodPost, "/x", nil)
+	testRequest(t, ts, http.MethodPost, "/r/known", nil)
+	if len(logger.msgs) != 0 {
+		t.Fatalf("expected no logs when silenced, got %v", logger.msgs)
+	}
+}
+
 // TestDefaultLoggerIsNoop verifies the default logger neither logs nor panics
 // on the 405 path when no logger is configured.
 func TestDefaultLoggerIsNoop(t *testing.T) {
@@ -856,6 +959,2696 @@ func TestDefaultLoggerIsNoop(t *testing.T) {
 	}})
 }
 
+// TestRouteSamePatternMerges verifies that calling Route twice with the same
+// pattern merges the children into one sub-Router instead of the second mount
+// clobbering the first.
+func TestRouteSamePatternMerges(t *testing.T) {
+	m := New()
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^first$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("first"))
+		})
+	})
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^second$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("second"))
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "child from first Route",
+			path:           "/api/first",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "first",
+		}, {
+			name:           "child from second Route",
+			path:           "/api/second",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "second",
+		},
+	})
+}
+
+// TestMatchRawURI verifies that WithMatchRawURI lets a pattern match on the
+// query string, while the default only sees the path.
+func TestMatchRawURI(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("legacy home"))
+	}
+
+	raw := New(WithMatchRawURI())
+	raw.Get(`^/legacy\?page=home$`, handler)
+	tsRaw := httptest.NewServer(raw)
+	defer tsRaw.Close()
+	runTestCases(t, tsRaw, []testCase{
+		{
+			name:           "query matched",
+			path:           "/legacy?page=home",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "legacy home",
+		}, {
+			name:           "other query not matched",
+			path:           "/legacy?page=about",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+
+	def := New()
+	def.Get(`^/legacy\?page=home$`, handler)
+	tsDef := httptest.NewServer(def)
+	defer tsDef.Close()
+	runTestCases(t, tsDef, []testCase{{
+		name:           "query ignored by default",
+		path:           "/legacy?page=home",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusNotFound,
+		expectedBody:   "not found",
+	}})
+}
+
+// TestWithNormalizer verifies a path in decomposed Unicode form matches a
+// pattern written with the precomposed character once normalized.
+func TestWithNormalizer(t *testing.T) {
+	// A stand-in for norm.NFC.String covering the one character under test.
+	nfc := func(s string) string { return strings.ReplaceAll(s, "e\u0301", "\u00e9") }
+
+	m := New(WithNormalizer(nfc))
+	m.Get("^/caf\u00e9/(?P<item>.+)$", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(URLParam(r, "item")))
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "precomposed path",
+			path:           "/caf%C3%A9/cr%C3%A8me",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "crème",
+		}, {
+			name:           "decomposed path",
+			path:           "/cafe%CC%81/cr%C3%A8me",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "crème",
+		},
+	})
+}
+
+// TestWithMatchCleanedPath verifies messy paths match their cleaned form
+// while the handler still sees the path as sent.
+func TestWithMatchCleanedPath(t *testing.T) {
+	m := New(WithMatchCleanedPath())
+	m.Get(`^/foo/bar$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	m.Get(`^/dir/$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("dir"))
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "repeated slash",
+			path:           "/foo//bar",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "/foo//bar",
+		}, {
+			name:           "dot elements",
+			path:           "/foo/./baz/../bar",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "/foo/./baz/../bar",
+		}, {
+			name:           "trailing slash kept",
+			path:           "/dir//",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "dir",
+		},
+	})
+}
+
+// TestHandlerFuncE verifies an error returned from a GetE handler is passed to
+// the configured error handler, including from within a sub-Router.
+func TestHandlerFuncE(t *testing.T) {
+	errInvalid := errors.New("invalid widget")
+	m := New(WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, errInvalid) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(err.Error()))
+	}))
+	m.GetE(`^/ok$`, func(w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte("ok"))
+		return nil
+	})
+	m.Route(`^/widgets/(?P<subroute>.*)$`, func(r Router) {
+		r.GetE(`^bad$`, func(w http.ResponseWriter, r *http.Request) error {
+			return errInvalid
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "nil error writes normally",
+			path:           "/ok",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		}, {
+			name:           "error mapped to 422",
+			path:           "/widgets/bad",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   "invalid widget",
+		},
+	})
+}
+
+// TestRemove verifies a removed route stops matching, that removing one of
+// several methods leaves the others in place, and that removing an unknown
+// registration reports false.
+func TestRemove(t *testing.T) {
+	m := New()
+	m.Get(`^/plugin$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("get"))
+	})
+	m.Post(`^/plugin$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("post"))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	if !m.Remove("post", `^/plugin$`) {
+		t.Fatal("expected Remove of a registered method to report true")
+	}
+	if m.Remove(http.MethodPost, `^/plugin$`) {
+		t.Fatal("expected a second Remove of the same method to report false")
+	}
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "remaining method still served",
+			path:           "/plugin",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "get",
+		}, {
+			name:           "removed method is 405",
+			path:           "/plugin",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   "not allowed",
+		},
+	})
+
+	if !m.Remove(http.MethodGet, `^/plugin$`) {
+		t.Fatal("expected Remove of the last method to report true")
+	}
+	runTestCases(t, ts, []testCase{{
+		name:           "route gone once empty",
+		path:           "/plugin",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusNotFound,
+		expectedBody:   "not found",
+	}})
+}
+
+// TestHeadOnAllMethodsRoute verifies a HEAD request served by a HandleFunc
+// (all methods) route gets the handler's headers but no body. A recorder is
+// used because net/http's server already discards HEAD bodies on the wire.
+func TestHeadOnAllMethodsRoute(t *testing.T) {
+	m := New()
+	m.HandleFunc(`^/all$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "all")
+		w.Write([]byte("body"))
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/all", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Handler") != "all" {
+		t.Fatalf("expected 200 with X-Handler header, got %d %v", rec.Code, rec.Header())
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected no body for HEAD, got %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/all", nil))
+	if rec.Body.String() != "body" {
+		t.Fatalf("expected body for GET, got %q", rec.Body.String())
+	}
+}
+
+// TestSubrouteRemainderEmpty verifies the remainder handed to a sub-Router is
+// "" (so `^$` matches) when the path ends right after the mount prefix, and
+// when a nested Route without a subroute group sits inside one that has it.
+func TestSubrouteRemainderEmpty(t *testing.T) {
+	m := New()
+	m.Route(`^/route1/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("index"))
+		})
+		// No subroute group: must not inherit the enclosing remainder "leaf".
+		r.Route(`^leaf$`, func(r Router) {
+			r.Get(`^$`, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("leaf"))
+			})
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "trailing slash after prefix",
+			path:           "/route1/",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "index",
+		}, {
+			name:           "nested Route without subroute group",
+			path:           "/route1/leaf",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "leaf",
+		},
+	})
+}
+
+// TestMuxMiddlewares verifies Middlewares reports the effective stack of a
+// grouped mux, parent middleware first.
+func TestMuxMiddlewares(t *testing.T) {
+	var calls []string
+	track := func(label string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, label)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	m := New()
+	m.Use(track("1"), track("2"))
+	var group *Mux
+	m.Group(func(r Router) {
+		r.Use(track("a"))
+		group = r.(*Mux)
+	})
+
+	if n := len(m.Middlewares()); n != 2 {
+		t.Fatalf("expected 2 middlewares on the root, got %d", n)
+	}
+	mws := group.Middlewares()
+	if len(mws) != 3 {
+		t.Fatalf("expected 3 middlewares on the group, got %d", len(mws))
+	}
+	// Run the stack to check its composition and order.
+	var h http.Handler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := strings.Join(calls, " "); got != "1 2 a" {
+		t.Fatalf("expected middleware order \"1 2 a\", got %q", got)
+	}
+}
+
+// TestLiteralBeforeParam verifies literal and parameterized routes registered
+// in the "wrong" order: by default the first registered wins, deterministically,
+// and under WithMostSpecificMatch the literal wins regardless of order.
+func TestLiteralBeforeParam(t *testing.T) {
+	register := func(m *Mux) {
+		m.Get(`^/items/(?P<id>[^/]+)$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("item " + URLParam(r, "id")))
+		})
+		m.Get(`^/items/new$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("new"))
+		})
+	}
+
+	def := New()
+	register(def)
+	tsDef := httptest.NewServer(def)
+	defer tsDef.Close()
+	runTestCases(t, tsDef, []testCase{{
+		name:           "first registered wins by default",
+		path:           "/items/new",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusOK,
+		expectedBody:   "item new",
+	}})
+
+	specific := New(WithMostSpecificMatch())
+	register(specific)
+	tsSpecific := httptest.NewServer(specific)
+	defer tsSpecific.Close()
+	runTestCases(t, tsSpecific, []testCase{
+		{
+			name:           "literal wins under most-specific matching",
+			path:           "/items/new",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "new",
+		}, {
+			name:           "param route still matches other items",
+			path:           "/items/42",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "item 42",
+		},
+	})
+}
+
+// TestRedirect verifies Redirect expands named and positional captures,
+// path-escaped, into the target, leaving a lone "$" alone, responds with the
+// given status, and that a non-redirect status panics.
+func TestRedirect(t *testing.T) {
+	m := New()
+	m.Redirect(`^/old/(?P<id>\d+)$`, "/new/${id}", http.StatusPermanentRedirect)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/old/42", nil))
+	if rec.Code != http.StatusPermanentRedirect {
+		t.Fatalf("expected status 308, got %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/new/42" {
+		t.Fatalf("expected Location /new/42, got %q", loc)
+	}
+
+	m.Redirect(`^/docs/([a-z]+)/(?P<rest>.*)$`, "/v2/$1/${rest}?price=$$5&sym=$", http.StatusFound)
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.URL.Path = "/docs/guide/a b/c?d"
+	m.ServeHTTP(rec, req)
+	if loc, want := rec.Header().Get("Location"), "/v2/guide/a%20b/c%3Fd?price=$5&sym=$"; loc != want {
+		t.Fatalf("expected Location %q, got %q", want, loc)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Redirect with a non-redirect status to panic")
+		}
+	}()
+	m.Redirect(`^/bad$`, "/", http.StatusOK)
+}
+
+// TestPackageDefaultHandlers verifies a fresh mux with no handlers of its own
+// uses the package-level defaults, and that a mux's own handler still wins.
+func TestPackageDefaultHandlers(t *testing.T) {
+	SetDefaultNotFoundHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("PACKAGE-404"))
+	})
+	SetDefaultMethodNotAllowedHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("PACKAGE-405"))
+	})
+	defer SetDefaultNotFoundHandler(nil)
+	defer SetDefaultMethodNotAllowedHandler(nil)
+
+	m := New()
+	m.Get(`^/x$`, func(w http.ResponseWriter, r *http.Request) {})
+	own := New(WithNotFoundHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("OWN-404"))
+	}))
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	tsOwn := httptest.NewServer(own)
+	defer tsOwn.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "package default 404",
+			path:           "/missing",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "PACKAGE-404",
+		}, {
+			name:           "package default 405",
+			path:           "/x",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   "PACKAGE-405",
+		},
+	})
+	runTestCases(t, tsOwn, []testCase{{
+		name:           "mux handler overrides package default",
+		path:           "/missing",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusNotFound,
+		expectedBody:   "OWN-404",
+	}})
+}
+
+// TestResource verifies a Resource answers OPTIONS and HEAD for its declared
+// method set and rejects other methods with a 405 carrying the same Allow.
+func TestResource(t *testing.T) {
+	m := New()
+	m.Resource(`^/widgets$`, map[string]http.HandlerFunc{
+		http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("list"))
+		},
+		"post": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("create"))
+		},
+	})
+
+	for _, tc := range []struct {
+		method     string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{http.MethodGet, http.StatusOK, "list", ""},
+		{http.MethodPost, http.StatusOK, "create", ""},
+		{http.MethodHead, http.StatusOK, "", ""},
+		{http.MethodOptions, http.StatusNoContent, "", "GET, POST"},
+		{http.MethodDelete, http.StatusMethodNotAllowed, "not allowed", "GET, POST"},
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(tc.method, "/widgets", nil))
+		if rec.Code != tc.wantStatus || rec.Body.String() != tc.wantBody {
+			t.Fatalf("%s: expected %d %q, got %d %q", tc.method, tc.wantStatus, tc.wantBody, rec.Code, rec.Body.String())
+		}
+		if allow := rec.Header().Get("Allow"); allow != tc.wantAllow {
+			t.Fatalf("%s: expected Allow %q, got %q", tc.method, tc.wantAllow, allow)
+		}
+	}
+}
+
+// TestLen verifies Len counts top-level routes plus the children of mounted
+// sub-Routers.
+func TestLen(t *testing.T) {
+	m := New()
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	m.Get(`^/$`, h)
+	m.Post(`^/$`, h) // same pattern: still one route
+	m.Get(`^/about$`, h)
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^a$`, h)
+		r.Get(`^b$`, h)
+	})
+
+	if n := m.Len(); n != 4 {
+		t.Fatalf("expected 4 routes, got %d", n)
+	}
+}
+
+// TestURLParamDecoded verifies an encoded capture is decoded on demand.
+func TestURLParamDecoded(t *testing.T) {
+	m := New(WithMatchRawURI())
+	m.Get(`^/v2/(?P<name>[^/]+)/manifests/(?P<reference>[^/?]+)$`, func(w http.ResponseWriter, r *http.Request) {
+		ref, err := URLParamDecoded(r, "reference")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%s %s", URLParam(r, "reference"), ref)
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{{
+		name:           "encoded capture decoded",
+		path:           "/v2/foo/manifests/sha256%3Aabc",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusOK,
+		expectedBody:   "sha256%3Aabc sha256:abc",
+	}})
+}
+
+// TestURLParamSegments verifies a rest capture is split into its segments,
+// ignoring empty ones.
+func TestURLParamSegments(t *testing.T) {
+	m := New()
+	m.Get(`^/files/(?P<rest>.*)$`, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%q", URLParamSegments(r, "rest"))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "nested path",
+			path:           "/files/a/b/c",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `["a" "b" "c"]`,
+		}, {
+			name:           "empty segments trimmed",
+			path:           "/files/a//b/",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `["a" "b"]`,
+		}, {
+			name:           "no segments",
+			path:           "/files/",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+		},
+	})
+}
+
+// TestWrap verifies middleware passed to Wrap runs for unmatched requests too,
+// in the order given.
+func TestWrap(t *testing.T) {
+	tag := func(v string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Wrapped", v)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	m := New()
+	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	m.Wrap(tag("outer"), tag("inner")).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	if got := strings.Join(rec.Header().Values("X-Wrapped"), " "); got != "outer inner" {
+		t.Fatalf("expected wrapping middleware to run as \"outer inner\" on a 404, got %q", got)
+	}
+}
+
+// TestMountFS verifies files are served from an fs.FS under a prefix, and that
+// a missing file gets the file server's 404.
+func TestMountFS(t *testing.T) {
+	m := New()
+	m.MountFS(`^/static/(?P<subroute>.*)$`, fstest.MapFS{
+		"css/app.css": &fstest.MapFile{Data: []byte("body{}")},
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "file served with prefix stripped",
+			path:           "/static/css/app.css",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "body{}",
+		}, {
+			name:           "missing file",
+			path:           "/static/missing.js",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "404 page not found\n",
+		}, {
+			name:           "other methods not allowed",
+			path:           "/static/css/app.css",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   "not allowed",
+		},
+	})
+}
+
+// TestURLParamIndex verifies positional access to named and unnamed captures
+// agrees with the full slice.
+func TestURLParamIndex(t *testing.T) {
+	m := New()
+	m.Get(`^/(\w+)/(?P<id>\d+)/(\w+)$`, func(w http.ResponseWriter, r *http.Request) {
+		all := URLParamsAll(r)
+		fmt.Fprintf(w, "%s|%s %s %s %q", strings.Join(all, ","),
+			URLParamIndex(r, 0), URLParamIndex(r, 1), URLParamIndex(r, 2), URLParamIndex(r, 3))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{{
+		name:           "indexed and full-slice accessors agree",
+		path:           "/users/42/edit",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusOK,
+		expectedBody:   `users,42,edit|users 42 edit ""`,
+	}})
+}
+
+// TestMatchedText verifies the matched text of an unanchored pattern is the
+// subspan it matched, not the whole path.
+func TestMatchedText(t *testing.T) {
+	m := New()
+	m.Get(`v[0-9]+/users`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(MatchedText(r)))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{{
+		name:           "unanchored subspan",
+		path:           "/api/v2/users/list",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusOK,
+		expectedBody:   "v2/users",
+	}})
+}
+
+// TestWithOnUnmatched verifies the callback fires once for unmatched paths,
+// including sub-Router misses, and that the 404 is still served.
+func TestWithOnUnmatched(t *testing.T) {
+	var unmatched []string
+	m := New(WithOnUnmatched(func(r *http.Request) {
+		unmatched = append(unmatched, r.URL.Path)
+	}))
+	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {})
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^known$`, func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "matched path",
+			path:           "/",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "",
+		}, {
+			name:           "top-level miss",
+			path:           "/missing",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		}, {
+			name:           "sub-Router miss",
+			path:           "/api/missing",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+	if got := strings.Join(unmatched, " "); got != "/missing /api/missing" {
+		t.Fatalf("expected callbacks for the two misses, got %q", got)
+	}
+}
+
+// TestMatchedViaAny verifies the accessor distinguishes all-methods routes
+// from method-specific ones, including a method-specific sub-route reached
+// through Route's all-methods mount.
+func TestMatchedViaAny(t *testing.T) {
+	report := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, MatchedViaAny(r))
+	}
+	m := New()
+	m.HandleFunc(`^/any$`, report)
+	m.Get(`^/get$`, report)
+	m.Route(`^/sub/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^get$`, report)
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "all-methods route",
+			path:           "/any",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "true",
+		}, {
+			name:           "method-specific route",
+			path:           "/get",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "false",
+		}, {
+			name:           "method-specific sub-route",
+			path:           "/sub/get",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "false",
+		},
+	})
+}
+
+// TestMethodRegexp verifies routes can be registered with a compiled pattern
+// and that it shares a route with the equivalent string pattern.
+func TestMethodRegexp(t *testing.T) {
+	re := regexp.MustCompile(`^/items/(?P<id>\d+)$`)
+	m := New()
+	m.MethodRegexp(http.MethodGet, re, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("get " + URLParam(r, "id")))
+	}))
+	m.Post(re.String(), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("post " + URLParam(r, "id")))
+	})
+	if n := m.Len(); n != 1 {
+		t.Fatalf("expected the compiled and string patterns to share one route, got %d", n)
+	}
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "compiled pattern dispatches",
+			path:           "/items/7",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "get 7",
+		}, {
+			name:           "string registration on the same route",
+			path:           "/items/7",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "post 7",
+		},
+	})
+}
+
+// TestRouteName verifies handlers can read the name of the matched route, that
+// unnamed routes report "", and that a named mount covers unnamed children.
+func TestRouteName(t *testing.T) {
+	report := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(RouteName(r)))
+	}
+	m := New()
+	m.Get(`^/users/(?P<id>[0-9]+)$`, report)
+	m.Name("user")
+	m.Get(`^/unnamed$`, report)
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^widgets$`, report)
+	})
+	m.Name("api")
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "named route",
+			path:           "/users/1",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "user",
+		}, {
+			name:           "unnamed route",
+			path:           "/unnamed",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "",
+		}, {
+			name:           "unnamed child of named mount",
+			path:           "/api/widgets",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "api",
+		},
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Name before any registration to panic")
+		}
+	}()
+	New().Name("orphan")
+}
+
+// TestDuplicateGroupNamePanics verifies a pattern repeating a capture group
+// name is rejected by ValidPattern and panics at registration, rather than one
+// value silently winning.
+func TestDuplicateGroupNamePanics(t *testing.T) {
+	const pattern = `^/(?P<id>[a-z]+)/(?P<id>[0-9]+)$`
+	if ValidPattern(pattern) == nil {
+		t.Fatal("expected ValidPattern to reject a duplicate group name")
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("expected registering a duplicate group name to panic")
+		}
+		if msg, ok := r.(string); !ok || !strings.Contains(msg, "duplicate capture group name") {
+			t.Fatalf("panic message not actionable: %v", r)
+		}
+	}()
+	New().Get(pattern, func(w http.ResponseWriter, r *http.Request) {})
+}
+
+// TestGlobalOptions verifies the global OPTIONS handler answers unrouted and
+// all-methods paths, including inside a sub-Router, while explicit OPTIONS
+// routes and Resource routes still win.
+func TestGlobalOptions(t *testing.T) {
+	m := New()
+	m.GlobalOptions(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	m.HandleFunc(`^/any$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("any"))
+	})
+	m.Options(`^/explicit$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("explicit"))
+	})
+	m.Resource(`^/resource$`, map[string]http.HandlerFunc{
+		http.MethodGet: func(w http.ResponseWriter, r *http.Request) {},
+	})
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Options(`^explicit$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("sub explicit"))
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "preflight to unrouted path",
+			path:           "/nowhere",
+			method:         http.MethodOptions,
+			expectedStatus: http.StatusNoContent,
+			expectedBody:   "",
+		}, {
+			name:           "preflight to all-methods route",
+			path:           "/any",
+			method:         http.MethodOptions,
+			expectedStatus: http.StatusNoContent,
+			expectedBody:   "",
+		}, {
+			name:           "explicit OPTIONS route wins",
+			path:           "/explicit",
+			method:         http.MethodOptions,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "explicit",
+		}, {
+			name:           "explicit OPTIONS sub-route wins",
+			path:           "/api/explicit",
+			method:         http.MethodOptions,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "sub explicit",
+		}, {
+			name:           "preflight to unrouted sub-Router path",
+			path:           "/api/nowhere",
+			method:         http.MethodOptions,
+			expectedStatus: http.StatusNoContent,
+			expectedBody:   "",
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/resource", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != "GET" || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("expected Resource's own OPTIONS answer, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+// TestRejectControlChars verifies an encoded NUL byte or newline in the path
+// gets a 400 before matching, even under raw-URI matching where the encoded
+// form would otherwise match.
+func TestRejectControlChars(t *testing.T) {
+	m := New(WithRejectControlChars(), WithMatchRawURI())
+	m.Get(`^/files/(?P<name>.*)$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(URLParam(r, "name")))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "clean path",
+			path:           "/files/a.txt",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "a.txt",
+		}, {
+			name:           "NUL byte",
+			path:           "/files/a.txt%00.jpg",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "bad request",
+		}, {
+			name:           "newline",
+			path:           "/files/a%0Ab",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "bad request",
+		},
+	})
+}
+
+// TestAfter verifies after hooks run once per matched request, with the
+// matched request's parameters, even when the handler panics, and not for
+// unmatched requests.
+func TestAfter(t *testing.T) {
+	var ran []string
+	m := New()
+	m.After(func(r *http.Request) {
+		ran = append(ran, URLParam(r, "name"))
+	})
+	m.Get(`^/hello/(?P<name>\w+)$`, func(w http.ResponseWriter, r *http.Request) {})
+	m.Get(`^/panic$`, func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello/world", nil))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	func() {
+		defer func() { recover() }()
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	}()
+
+	if got := strings.Join(ran, ","); got != "world," {
+		t.Fatalf("expected the hook to run for the match and the panic only, got %q", got)
+	}
+}
+
+// TestHandlerFor verifies the stored handler, middleware included, can be
+// retrieved and invoked directly.
+func TestHandlerFor(t *testing.T) {
+	m := New()
+	m.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Mw", "applied")
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.Get(`^/ping$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	h, ok := m.HandlerFor("get", `^/ping$`)
+	if !ok {
+		t.Fatal("expected a handler for GET ^/ping$")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/anything", nil))
+	if rec.Body.String() != "pong" || rec.Header().Get("X-Mw") != "applied" {
+		t.Fatalf("expected the middleware-wrapped handler, got %q %v", rec.Body.String(), rec.Header())
+	}
+
+	if _, ok := m.HandlerFor(http.MethodPost, `^/ping$`); ok {
+		t.Fatal("expected no handler for an unregistered method")
+	}
+	if _, ok := m.HandlerFor(http.MethodGet, `^/pong$`); ok {
+		t.Fatal("expected no handler for an unregistered pattern")
+	}
+
+	m.Prefix("/api", func(r Router) {
+		r.Get(`^/ping$`, func(w http.ResponseWriter, r *http.Request) {})
+		if _, ok := r.(*Mux).HandlerFor(http.MethodGet, `^/ping$`); !ok {
+			t.Fatal("expected a handler for GET ^/ping$ under Prefix")
+		}
+	})
+	if _, ok := m.HandlerFor(http.MethodGet, `^/api(?:/ping$)`); !ok {
+		t.Fatal("expected a handler for GET ^/api(?:/ping$)")
+	}
+}
+
+// TestRejectBodyOnGet verifies a GET with a body gets a 400 when enabled,
+// while bodiless GETs and POSTs with bodies are served.
+func TestRejectBodyOnGet(t *testing.T) {
+	m := New(WithRejectBodyOnGet())
+	m.HandleFunc(`^/x$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "GET without body",
+			path:           "/x",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		}, {
+			name:           "GET with body",
+			path:           "/x",
+			method:         http.MethodGet,
+			body:           strings.NewReader("payload"),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "bad request",
+		}, {
+			name:           "POST with body",
+			path:           "/x",
+			method:         http.MethodPost,
+			body:           strings.NewReader("payload"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+	})
+}
+
+// TestMethodRecover verifies a route's panic is turned into its custom payload.
+func TestMethodRecover(t *testing.T) {
+	m := New()
+	m.MethodRecover(http.MethodGet, `^/experimental$`, func(w http.ResponseWriter, r *http.Request, v any) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "experimental feature failed: %v", v)
+	}, func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{{
+		name:           "panic produces custom payload",
+		path:           "/experimental",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusServiceUnavailable,
+		expectedBody:   "experimental feature failed: boom",
+	}})
+}
+
+// TestUseRetroactive verifies middleware added retroactively wraps routes
+// registered before it, once each, including sub-routes, but not later ones.
+func TestUseRetroactive(t *testing.T) {
+	var count int
+	report := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, count)
+	}
+	m := New()
+	m.Get(`^/before$`, report)
+	m.Route(`^/sub/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^before$`, report)
+	})
+	m.UseRetroactive(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count++
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.Get(`^/after$`, report)
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "earlier route wrapped",
+			path:           "/before",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "1",
+		}, {
+			name:           "earlier sub-route wrapped once",
+			path:           "/sub/before",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "2",
+		}, {
+			name:           "later route not wrapped",
+			path:           "/after",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "2",
+		},
+	})
+}
+
+// TestCaseInsensitiveCapturesKeepCasing verifies that under WithCaseInsensitive
+// patterns match regardless of case while captures keep the request's casing.
+func TestCaseInsensitiveCapturesKeepCasing(t *testing.T) {
+	m := New(WithCaseInsensitive())
+	m.Get(`^/users/(?P<name>[a-z]+)$`, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", URLParam(r, "name"), r.Pattern)
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{{
+		name:           "capture keeps original casing",
+		path:           "/USERS/FooBar",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusOK,
+		expectedBody:   "FooBar ^/users/(?P<name>[a-z]+)$",
+	}})
+}
+
+// TestTry verifies a bad pattern inside Try is returned as an error, leaving
+// the routes registered before it in place, while other panics propagate.
+func TestTry(t *testing.T) {
+	m := New()
+	err := m.Try(func(r Router) {
+		r.Get(`^/ok$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+		r.Get(`^/bad(`, func(w http.ResponseWriter, r *http.Request) {})
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid route pattern") {
+		t.Fatalf("expected invalid pattern error, got %v", err)
+	}
+	if err := m.Try(func(r Router) {}); err != nil {
+		t.Fatalf("expected nil error, got %v", err)
+	}
+	func() {
+		defer func() {
+			if _, ok := recover().(runtime.Error); !ok {
+				t.Fatal("expected a runtime error in fn to keep panicking")
+			}
+		}()
+		m.Try(func(r Router) {
+			var routes map[string]bool
+			routes["x"] = true
+		})
+	}()
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "route before failure registered",
+			path:           "/ok",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+	})
+}
+
+// TestGetAliases verifies every alias routes to the shared handler.
+func TestGetAliases(t *testing.T) {
+	m := New()
+	m.GetAliases([]string{`^/user$`, `^/users$`}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users " + r.URL.Path))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "singular alias",
+			path:           "/user",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "users /user",
+		}, {
+			name:           "plural alias",
+			path:           "/users",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "users /users",
+		}, {
+			name:           "other method",
+			path:           "/users",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   "not allowed",
+		},
+	})
+}
+
+// TestMethodNotAllowedAllowHeader verifies a 405 lists the methods of the
+// routes matching the path, taken from the sub-Router's own route when the
+// request was routed through one.
+func TestMethodNotAllowedAllowHeader(t *testing.T) {
+	m := New()
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	m.Get(`^/items$`, noop)
+	m.Post(`^/items$`, noop)
+	m.Delete(`^/items$`, noop)
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^widgets$`, noop)
+	})
+
+	for _, tc := range []struct {
+		path      string
+		wantAllow string
+	}{
+		{"/items", "DELETE, GET, POST"},
+		{"/api/widgets", "GET"},
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, tc.path, nil))
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != tc.wantAllow {
+			t.Fatalf("%s: expected 405 with Allow %q, got %d with Allow %q", tc.path, tc.wantAllow, rec.Code, rec.Header().Get("Allow"))
+		}
+	}
+}
+
+// TestInsertMethod verifies a route inserted at index 0 takes precedence over
+// a broader one registered before it.
+func TestInsertMethod(t *testing.T) {
+	m := New()
+	m.Get(`^/users/(?P<id>[^/]+)$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + URLParam(r, "id")))
+	})
+	m.InsertMethod(0, http.MethodGet, `^/users/me$`, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("current user"))
+	}))
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "inserted route wins",
+			path:           "/users/me",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "current user",
+		}, {
+			name:           "broad route still serves others",
+			path:           "/users/42",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "user 42",
+		},
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for an out-of-range index")
+		}
+	}()
+	m.InsertMethod(5, http.MethodGet, `^/other$`, http.NotFoundHandler())
+}
+
+// TestWithMatchTimeout verifies a request whose matching outlasts the budget
+// gets a 503, while one matched early is served.
+func TestWithMatchTimeout(t *testing.T) {
+	slow := func(r *http.Request) bool {
+		time.Sleep(10 * time.Millisecond)
+		return false
+	}
+	m := New(WithMatchTimeout(15 * time.Millisecond))
+	m.Get(`^/fast$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	})
+	for i := 0; i < 5; i++ {
+		m.When(slow).Get(`^/slow$`, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	m.Get(`^/slow$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("slow"))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "matched within budget",
+			path:           "/fast",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "fast",
+		}, {
+			name:           "budget exceeded",
+			path:           "/slow",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "service unavailable",
+		},
+	})
+}
+
+// TestHandler verifies the typed adapter passes extracted params to the
+// handler and answers 400 when extraction fails.
+func TestHandler(t *testing.T) {
+	type userParams struct{ ID int }
+	m := New()
+	m.Get(`^/users/(?P<id>[^/]+)$`, Handler(
+		func(r *http.Request) (userParams, error) {
+			id, err := strconv.Atoi(URLParam(r, "id"))
+			return userParams{ID: id}, err
+		},
+		func(w http.ResponseWriter, r *http.Request, p userParams) {
+			fmt.Fprintf(w, "user %d", p.ID+1)
+		},
+	))
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "valid id",
+			path:           "/users/41",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "user 42",
+		}, {
+			name:           "invalid id",
+			path:           "/users/me",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "bad request",
+		},
+	})
+}
+
+// TestWithTraceEcho verifies a TRACE to a matched path echoes the request
+// without credentials, and that TRACE stays a 405 by default.
+func TestWithTraceEcho(t *testing.T) {
+	for _, echo := range []bool{true, false} {
+		var opts []Option
+		if echo {
+			opts = append(opts, WithTraceEcho())
+		}
+		m := New(opts...)
+		m.Get(`^/diag$`, func(w http.ResponseWriter, r *http.Request) {})
+
+		req := httptest.NewRequest(http.MethodTrace, "/diag", nil)
+		req.Header.Set("X-Probe", "1")
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+
+		if !echo {
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("expected 405 with echo disabled, got %d", rec.Code)
+			}
+			continue
+		}
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "message/http" {
+			t.Fatalf("expected 200 message/http, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if !strings.HasPrefix(body, "TRACE /diag HTTP/1.1\r\n") || !strings.Contains(body, "X-Probe: 1") {
+			t.Fatalf("expected echoed request line and headers, got %q", body)
+		}
+		if strings.Contains(body, "secret") {
+			t.Fatalf("expected credentials left out of the echo, got %q", body)
+		}
+	}
+}
+
+// TestSubMux verifies a SubMux keeps its routes out of the parent's table
+// until mounted, and that the parent middleware it inherits runs once.
+func TestSubMux(t *testing.T) {
+	m := New()
+	m.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Parent", "1")
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {})
+
+	api := m.SubMux()
+	api.Get(`^widgets$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("widgets"))
+	})
+	if n := m.Len(); n != 1 {
+		t.Fatalf("expected 1 route in the parent before mounting, got %d", n)
+	}
+	if n := api.Len(); n != 1 {
+		t.Fatalf("expected 1 route in the SubMux, got %d", n)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/widgets", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before mounting, got %d", rec.Code)
+	}
+
+	m.Mount(`^/api/(?P<subroute>.*)$`, api)
+	if n := m.Len(); n != 2 {
+		t.Fatalf("expected 2 routes after mounting, got %d", n)
+	}
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/widgets", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "widgets" {
+		t.Fatalf("expected 200 %q after mounting, got %d %q", "widgets", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Values("X-Parent"); len(got) != 1 {
+		t.Fatalf("expected parent middleware to run once, got %v", got)
+	}
+}
+
+// TestNotFoundInfo verifies the not-found handler learns the attempted path
+// and whether the miss happened inside a sub-Router.
+func TestNotFoundInfo(t *testing.T) {
+	m := New(WithNotFoundHandler(func(w http.ResponseWriter, r *http.Request) {
+		path, subrouter := NotFoundInfo(r)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "%s %t", path, subrouter)
+	}))
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^known$`, func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "top-level miss",
+			path:           "/missing",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "/missing false",
+		}, {
+			name:           "sub-Router miss",
+			path:           "/api/unknown",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "unknown true",
+		},
+	})
+}
+
+// TestMethodLimit verifies a saturated route answers 429 while a sibling
+// route keeps serving.
+func TestMethodLimit(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	m := New()
+	m.MethodLimit(http.MethodGet, `^/export$`, 1, func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.Write([]byte("export"))
+	})
+	m.Get(`^/status$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("status"))
+	})
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		m.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/export", nil))
+		close(done)
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 while saturated, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "status" {
+		t.Fatalf("expected sibling route unaffected, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	close(release)
+	<-done
+	if first.Body.String() != "export" {
+		t.Fatalf("expected first request served, got %q", first.Body.String())
+	}
+	go func() { <-entered }()
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 once the slot is free, got %d", rec.Code)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected MethodLimit with a max of 0 to panic")
+		}
+	}()
+	m.MethodLimit(http.MethodGet, `^/none$`, 0, func(w http.ResponseWriter, r *http.Request) {})
+}
+
+// TestDispatch verifies a handler can re-dispatch a request under another
+// method, from the top level and within a sub-Router, and that a route
+// dispatching to itself is stopped.
+func TestDispatch(t *testing.T) {
+	m := New()
+	head := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Dispatched", r.Method+" "+r.Pattern)
+	}
+	m.Head(`^/probe$`, head)
+	m.Get(`^/probe$`, func(w http.ResponseWriter, r *http.Request) {
+		m.Dispatch(w, r, http.MethodHead)
+	})
+	m.Get(`^/loop$`, func(w http.ResponseWriter, r *http.Request) {
+		m.Dispatch(w, r, http.MethodGet)
+	})
+	m.Route(`^/api/(?P<subroute>.*)$`, func(sr Router) {
+		sr.Head(`^probe$`, head)
+		sr.Get(`^probe$`, func(w http.ResponseWriter, r *http.Request) {
+			sr.(*Mux).Dispatch(w, r, http.MethodHead)
+		})
+	})
+
+	for _, tc := range []struct {
+		path           string
+		wantCode       int
+		wantDispatched string
+	}{
+		{"/probe", http.StatusOK, "HEAD ^/probe$"},
+		{"/api/probe", http.StatusOK, "HEAD ^/api/(?P<subroute>.*)$ > ^probe$"},
+		{"/loop", http.StatusInternalServerError, ""},
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.wantCode || rec.Header().Get("X-Dispatched") != tc.wantDispatched {
+			t.Fatalf("%s: expected %d dispatched as %q, got %d as %q", tc.path, tc.wantCode, tc.wantDispatched, rec.Code, rec.Header().Get("X-Dispatched"))
+		}
+	}
+}
+
+// TestDispatchParams verifies the route Dispatch reaches sees its own
+// parameters and those of enclosing routes, not those of the caller's route.
+func TestDispatchParams(t *testing.T) {
+	m := New()
+	report := func(w http.ResponseWriter, r *http.Request) {
+		params := URLParams(r)
+		delete(params, SubrouteParam)
+		w.Write([]byte(fmt.Sprint(params)))
+	}
+	m.Get(`^/a/(?P<foo>\w+)$`, func(w http.ResponseWriter, r *http.Request) {
+		m.Dispatch(w, r, http.MethodPost)
+	})
+	m.Post(`^/a/\w+$`, report)
+	m.Route(`^/orgs/(?P<org>\w+)(?P<subroute>/.*)$`, func(sr Router) {
+		sr.Get(`^/x/(?P<foo>\w+)$`, func(w http.ResponseWriter, r *http.Request) {
+			sr.(*Mux).Dispatch(w, r, http.MethodPost)
+		})
+		sr.Post(`^/x/(?P<bar>\w+)$`, report)
+	})
+
+	for path, want := range map[string]string{
+		"/a/bar":         "map[]",
+		"/orgs/acme/x/1": "map[bar:1 org:acme]",
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Body.String() != want {
+			t.Fatalf("%s: expected params %s, got %s", path, want, rec.Body.String())
+		}
+	}
+}
+
+// TestWithCaptureQuery verifies query parameters are readable with URLParam,
+// and that a path parameter of the same name wins.
+func TestWithCaptureQuery(t *testing.T) {
+	m := New(WithCaptureQuery())
+	m.Get(`^/users/(?P<id>[0-9]+)$`, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", URLParam(r, "id"), URLParam(r, "page"))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "query param",
+			path:           "/users/7?page=2",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "7 2",
+		}, {
+			name:           "path param takes precedence",
+			path:           "/users/7?id=9&page=3",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "7 3",
+		},
+	})
+}
+
+// TestWithRecordPattern verifies r.Pattern is left unset when recording is
+// off, while parameters still reach routes that capture them and a sub-route
+// reports its own match rather than its mount's.
+func TestWithRecordPattern(t *testing.T) {
+	m := New(WithRecordPattern(false))
+	m.Get(`^/static$`, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%q", r.Pattern)
+	})
+	m.Get(`^/users/(?P<id>[0-9]+)$`, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%q %s", r.Pattern, URLParam(r, "id"))
+	})
+	m.Route(`^/api(?P<subroute>/.*)$`, func(r Router) {
+		r.Get(`^/plain$`, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%q %q %v", MatchedText(r), URLParamsAll(r), MatchedViaAny(r))
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "no params",
+			path:           "/static",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `""`,
+		}, {
+			name:           "with params",
+			path:           "/users/7",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"" 7`,
+		}, {
+			name:           "sub-router route without params",
+			path:           "/api/plain",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"/plain" [] false`,
+		},
+	})
+}
+
+// BenchmarkNoParamRoute measures serving a route without captures, with and
+// without pattern recording.
+func BenchmarkNoParamRoute(b *testing.B) {
+	for _, record := range []bool{true, false} {
+		b.Run(fmt.Sprintf("record=%t", record), func(b *testing.B) {
+			m := New(WithRecordPattern(record))
+			m.Get(`^/healthz$`, func(w http.ResponseWriter, r *http.Request) {})
+			req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			for b.Loop() {
+				req.Pattern = ""
+				m.ServeHTTP(w, req)
+			}
+		})
+	}
+}
+
+// TestUsePostMatch verifies post-match middleware sees the matched route,
+// including routes registered before it was added, and can reject by route.
+func TestUsePostMatch(t *testing.T) {
+	m := New()
+	m.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", "use")
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.Get(`^/internal$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	})
+	m.Get(`^/admin$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin"))
+	})
+	m.Meta("scope", "admin")
+	m.Get(`^/public$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("public"))
+	})
+	m.UsePostMatch(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", "post-match")
+			if r.Pattern == `^/internal$` || RouteMeta(r, "scope") == "admin" && r.Header.Get("X-Scope") != "admin" {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("forbidden"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "rejected by pattern",
+			path:           "/internal",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "forbidden",
+		}, {
+			name:           "rejected by metadata",
+			path:           "/admin",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "forbidden",
+		}, {
+			name:           "allowed",
+			path:           "/public",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "public",
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/public", nil))
+	if got := rec.Header().Values("X-Order"); !slices.Equal(got, []string{"post-match", "use"}) {
+		t.Fatalf("expected post-match middleware to run outside Use middleware, got %q", got)
+	}
+}
+
+// TestWithRequireLeadingSlash verifies a top-level pattern without a leading
+// slash panics, while relative sub-Router patterns are accepted.
+func TestWithRequireLeadingSlash(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	m := New(WithRequireLeadingSlash())
+	m.Get(`^/users$`, noop)
+	m.Group(func(r Router) {
+		r.Get(`/health$`, noop)
+	})
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^$`, noop)
+		r.Get(`^widgets$`, noop)
+	})
+
+	for _, pattern := range []string{`users$`, `^users$`} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for pattern %q", pattern)
+				}
+			}()
+			m.Group(func(r Router) {
+				r.Get(pattern, noop)
+			})
+		}()
+	}
+}
+
+// TestWithServerTiming verifies the Server-Timing header carries a parseable
+// matching duration, including on a 404.
+func TestWithServerTiming(t *testing.T) {
+	m := New(WithServerTiming())
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^widgets$`, func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	for _, path := range []string{"/api/widgets", "/missing"} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		values := rec.Header().Values("Server-Timing")
+		if len(values) != 1 {
+			t.Fatalf("%s: expected one Server-Timing header, got %q", path, values)
+		}
+		dur, ok := strings.CutPrefix(values[0], "route;dur=")
+		if !ok {
+			t.Fatalf("%s: expected route metric, got %q", path, values[0])
+		}
+		if ms, err := strconv.ParseFloat(dur, 64); err != nil || ms < 0 {
+			t.Fatalf("%s: expected a non-negative duration, got %q", path, dur)
+		}
+	}
+}
+
+// TestGetWithParams verifies a literal route supplies a fixed parameter to a
+// handler shared with a dynamic route, and that a captured value wins.
+func TestGetWithParams(t *testing.T) {
+	page := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page " + URLParam(r, "page")))
+	}
+	m := New()
+	m.Get(`^/pages/(?P<page>[a-z]+)$`, page)
+	m.GetWithParams(`^/legacy-home$`, map[string]string{"page": "home"}, page)
+	m.GetWithParams(`^/old/(?P<page>[a-z]+)$`, map[string]string{"page": "home"}, page)
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "dynamic route",
+			path:           "/pages/about",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "page about",
+		}, {
+			name:           "injected param",
+			path:           "/legacy-home",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "page home",
+		}, {
+			name:           "captured param wins",
+			path:           "/old/contact",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "page contact",
+		},
+	})
+}
+
+// TestWithPathValues verifies named captures are readable with the standard
+// r.PathValue, including a parent's inside a sub-Router, unless turned off,
+// and that a sub-route's captures stay off its mount's request.
+func TestWithPathValues(t *testing.T) {
+	for _, set := range []bool{true, false} {
+		m := New(WithPathValues(set))
+		var outer string
+		m.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r)
+				outer = r.PathValue("var2")
+			})
+		})
+		m.Get(`^/a/(?P<var1>[^/]+)$`, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%q", r.PathValue("var1"))
+		})
+		m.Route(`^/b/(?P<var1>[^/]+)/(?P<subroute>.*)$`, func(sr Router) {
+			sr.Get(`^(?P<var2>[^/]+)$`, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%q %q", r.PathValue("var1"), r.PathValue("var2"))
+			})
+		})
+
+		want := map[string]string{"/a/x": `"x"`, "/b/x/y": `"x" "y"`}
+		if !set {
+			want = map[string]string{"/a/x": `""`, "/b/x/y": `"" ""`}
+		}
+		for path, body := range want {
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			if rec.Body.String() != body {
+				t.Fatalf("set=%t %s: expected %s, got %s", set, path, body, rec.Body.String())
+			}
+			if outer != "" {
+				t.Fatalf("set=%t %s: expected the mount's request without the sub-route's var2, got %q", set, path, outer)
+			}
+		}
+	}
+}
+
+// TestWithDefaultMethod verifies HandleFunc registers for the default method
+// only, inside sub-Routers too, so other methods get a 405.
+func TestWithDefaultMethod(t *testing.T) {
+	m := New(WithDefaultMethod(http.MethodPost))
+	m.HandleFunc(`^/rpc/Echo$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("echo"))
+	})
+	m.Route(`^/admin/(?P<subroute>.*)$`, func(r Router) {
+		r.HandleFunc(`^Reset$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("reset"))
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "default method",
+			path:           "/rpc/Echo",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "echo",
+		}, {
+			name:           "other method",
+			path:           "/rpc/Echo",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   "not allowed",
+		}, {
+			name:           "sub-Router default method",
+			path:           "/admin/Reset",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "reset",
+		}, {
+			name:           "sub-Router other method",
+			path:           "/admin/Reset",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   "not allowed",
+		},
+	})
+}
+
+// TestTestHandler verifies a standalone module Mux served through
+// TestHandler sees the remainder after the prefix, as when mounted.
+func TestTestHandler(t *testing.T) {
+	widgets := New()
+	widgets.Get(`^$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("list"))
+	})
+	widgets.Get(`^(?P<id>[0-9]+)$`, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "widget %s via %s", URLParam(r, "id"), r.Pattern)
+	})
+
+	ts := httptest.NewServer(widgets.TestHandler("/api/widgets/"))
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "mount root",
+			path:           "/api/widgets/",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "list",
+		}, {
+			name:           "sub-route with params",
+			path:           "/api/widgets/7",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `widget 7 via ^/api/widgets/(?P<subroute>.*)$ > ^(?P<id>[0-9]+)$`,
+		}, {
+			name:           "outside the prefix",
+			path:           "/widgets/7",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+}
+
+// TestMiddlewareFor verifies the named middleware of a grouped route are
+// reported in execution order, leaving out unnamed ones and middleware added
+// after the route.
+func TestMiddlewareFor(t *testing.T) {
+	noop := func(next http.Handler) http.Handler { return next }
+	m := New()
+	m.UseNamed("1", noop)
+	m.Use(noop)
+	m.UseNamed("2", noop)
+	m.Get(`^/$`, func(w http.ResponseWriter, r *http.Request) {})
+	m.Group(func(r Router) {
+		r.(*Mux).UseNamed("a", noop)
+		r.Get(`^/foo$`, func(w http.ResponseWriter, r *http.Request) {})
+	})
+	m.UseNamed("late", noop)
+
+	for pattern, want := range map[string]string{
+		`^/$`:    "[1 2]",
+		`^/foo$`: "[1 2 a]",
+	} {
+		if got := fmt.Sprint(m.MiddlewareFor(http.MethodGet, pattern)); got != want {
+			t.Fatalf("%s: expected %s, got %s", pattern, want, got)
+		}
+	}
+	if got := m.MiddlewareFor(http.MethodPost, `^/foo$`); got != nil {
+		t.Fatalf("expected nil for an unregistered method, got %v", got)
+	}
+
+	m.Prefix("/api", func(r Router) {
+		r.(*Mux).UseNamed("api", noop)
+		r.Get(`^/bar$`, func(w http.ResponseWriter, r *http.Request) {})
+		if got := fmt.Sprint(r.(*Mux).MiddlewareFor(http.MethodGet, `^/bar$`)); got != "[1 2 late api]" {
+			t.Fatalf("under Prefix: expected [1 2 late api], got %s", got)
+		}
+	})
+}
+
+// TestContextWithVariant verifies a variant selected by middleware around
+// the router replaces the route's handler, and that variant registrations
+// stay out of the Allow header.
+func TestContextWithVariant(t *testing.T) {
+	m := New()
+	m.Get(`^/checkout$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("checkout v1"))
+	})
+	m.MethodFunc("GET@v2", `^/checkout$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("checkout v2"))
+	})
+	h := m.Wrap(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if v := r.Header.Get("X-Variant"); v != "" {
+				r = r.WithContext(ContextWithVariant(r.Context(), v))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	for _, tc := range []struct {
+		method, variant string
+		wantCode        int
+		wantBody        string
+	}{
+		{http.MethodGet, "", http.StatusOK, "checkout v1"},
+		{http.MethodGet, "v2", http.StatusOK, "checkout v2"},
+		{http.MethodGet, "v3", http.StatusOK, "checkout v1"},
+		{http.MethodPost, "v2", http.StatusMethodNotAllowed, "not allowed"},
+	} {
+		req := httptest.NewRequest(tc.method, "/checkout", nil)
+		req.Header.Set("X-Variant", tc.variant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.wantCode || rec.Body.String() != tc.wantBody {
+			t.Fatalf("%s variant %q: expected %d %q, got %d %q", tc.method, tc.variant, tc.wantCode, tc.wantBody, rec.Code, rec.Body.String())
+		}
+		if tc.wantCode == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != "GET" {
+			t.Fatalf("expected Allow %q, got %q", "GET", rec.Header().Get("Allow"))
+		}
+	}
+}
+
+// TestVariantsNotReported verifies variant handlers are left out of Routes,
+// the OpenAPI document and Allow headers, and that a route with only variant
+// handlers serves no method.
+func TestVariantsNotReported(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	m := New()
+	m.Get(`^/checkout$`, h)
+	m.MethodFunc("GET@v2", `^/checkout$`, h)
+	m.MethodFunc("GET@v2", `^/beta$`, h)
+
+	routes := m.Routes()
+	if !slices.Equal(routes[0].Methods, []string{"GET"}) || len(routes[1].Methods) != 0 {
+		t.Fatalf("expected variants to be left out of Routes, got %+v", routes)
+	}
+
+	rec := httptest.NewRecorder()
+	m.OpenAPIHandler(OpenAPIInfo{Title: "test", Version: "1.0"})(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if strings.Contains(rec.Body.String(), "@") {
+		t.Fatalf("expected no variant operations in the OpenAPI document, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/beta", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Allow") != "" {
+		t.Fatalf("expected a variant-only route to 404 without Allow, got %d (Allow %q)", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+// TestWithCompileStats verifies a compile time is recorded for every pattern
+// registered, including in sub-Routers, and that stats are off by default.
+func TestWithCompileStats(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	m := New(WithCompileStats())
+	m.Get(`^/$`, noop)
+	m.Get(`^/users/(?P<id>[0-9]+)$`, noop)
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^widgets$`, noop)
+	})
+
+	stats := m.CompileStats()
+	for _, pattern := range []string{`^/$`, `^/users/(?P<id>[0-9]+)$`, `^/api/(?P<subroute>.*)$`, `^widgets$`} {
+		if _, ok := stats[pattern]; !ok {
+			t.Fatalf("expected a compile stat for %q, got %v", pattern, stats)
+		}
+	}
+	if len(stats) != 4 {
+		t.Fatalf("expected 4 stats, got %d", len(stats))
+	}
+
+	plain := New()
+	plain.Get(`^/$`, noop)
+	if stats := plain.CompileStats(); stats != nil {
+		t.Fatalf("expected no stats by default, got %v", stats)
+	}
+}
+
+// TestFallback verifies a miss inside a sub-Router reaches the fallback with
+// the full original path, so a fallback Mux can route it again.
+func TestFallback(t *testing.T) {
+	upstream := New()
+	upstream.Get(`^/api/legacy$`, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "upstream %s", r.URL.Path)
+	})
+
+	m := New()
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^widgets$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("widgets"))
+		})
+	})
+	m.Fallback(upstream)
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "served locally",
+			path:           "/api/widgets",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "widgets",
+		}, {
+			name:           "sub-Router miss forwarded",
+			path:           "/api/legacy",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "upstream /api/legacy",
+		}, {
+			name:           "top-level miss forwarded",
+			path:           "/other",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+}
+
+// TestURLParams verifies URLParams collects the parameters of the route and
+// of the Route it is mounted under, the inner route winning a shared name.
+func TestURLParams(t *testing.T) {
+	m := New()
+	m.Route(`^/orgs/(?P<org>\w+)/(?P<id>\d+)(?P<subroute>/.*)`, func(r Router) {
+		r.Get(`^/repos/(?P<repo>\w+)/(?P<id>\w+)$`, func(w http.ResponseWriter, r *http.Request) {
+			got := URLParams(r)
+			want := map[string]string{"org": "acme", "repo": "api", "id": "main", "subroute": "/repos/api/main"}
+			if !maps.Equal(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+			if URLParamIndex(r, 1) != "main" {
+				t.Errorf("expected index 1 to be the sub-route's capture, got %q", URLParamIndex(r, 1))
+			}
+		})
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orgs/acme/7/repos/api/main", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+// BenchmarkURLParams measures dispatch to a four-group route and reading its
+// parameters, against storing each parameter as its own context value.
+func BenchmarkURLParams(b *testing.B) {
+	names := []string{"a", "b", "c", "d"}
+	read := func(r *http.Request, get func(*http.Request, string) string) {
+		for _, name := range names {
+			get(r, name)
+		}
+	}
+
+	b.Run("single", func(b *testing.B) {
+		m := New(WithRecordPattern(false), WithPathValues(false))
+		m.Get(`^/(?P<a>\w+)/(?P<b>\w+)/(?P<c>\w+)/(?P<d>\w+)$`, func(w http.ResponseWriter, r *http.Request) {
+			read(r, URLParam)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/w/x/y/z", nil)
+		w := httptest.NewRecorder()
+		b.ReportAllocs()
+		for b.Loop() {
+			m.ServeHTTP(w, req)
+		}
+	})
+
+	b.Run("per-key", func(b *testing.B) {
+		type key string
+		re := regexp.MustCompile(`^/(?P<a>\w+)/(?P<b>\w+)/(?P<c>\w+)/(?P<d>\w+)$`)
+		get := func(r *http.Request, name string) string {
+			v, _ := r.Context().Value(key(name)).(string)
+			return v
+		}
+		req := httptest.NewRequest(http.MethodGet, "/w/x/y/z", nil)
+		b.ReportAllocs()
+		for b.Loop() {
+			matches := re.FindStringSubmatch(req.URL.Path)
+			ctx := req.Context()
+			for i, name := range re.SubexpNames()[1:] {
+				ctx = context.WithValue(ctx, key(name), matches[i+1])
+			}
+			read(req.WithContext(ctx), get)
+		}
+	})
+}
+
+// TestPrefix verifies routes registered under Prefix, including nested
+// prefixes and a Route, match the full path as flat routes of the mux.
+func TestPrefix(t *testing.T) {
+	m := New()
+	m.Prefix("/api/v1", func(r Router) {
+		r.Get(`^/users/(?P<id>\d+)$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("user " + URLParam(r, "id")))
+		})
+		r.Prefix("/admin.", func(r Router) {
+			r.Get(`^stats$`, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("stats"))
+			})
+		})
+		r.Route(`^/orgs(?P<subroute>/.*)$`, func(r Router) {
+			r.Get(`^/(?P<org>\w+)$`, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("org " + URLParam(r, "org")))
+			})
+		})
+	})
+	m.Prefix("/admin", func(r Router) {
+		r.Get(`^/a$|^/b$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("admin"))
+		})
+	})
+
+	if _, ok := m.HandlerFor(http.MethodGet, `^/api/v1(?:/users/(?P<id>\d+)$)`); !ok {
+		t.Fatal("expected a flat route with the prefixed pattern")
+	}
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "prefixed route",
+			path:           "/api/v1/users/7",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "user 7",
+		}, {
+			name:           "without prefix",
+			path:           "/users/7",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		}, {
+			name:           "prefixed alternation",
+			path:           "/admin/b",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "admin",
+		}, {
+			name:           "alternation stays under the prefix",
+			path:           "/b",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		}, {
+			name:           "nested prefix",
+			path:           "/api/v1/admin.stats",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "stats",
+		}, {
+			name:           "prefix is literal",
+			path:           "/api/v1/adminxstats",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		}, {
+			name:           "prefixed sub-router",
+			path:           "/api/v1/orgs/acme",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "org acme",
+		},
+	})
+}
+
+// deadlineRecorder is a ResponseRecorder that records the write deadline set
+// through http.ResponseController.
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadline time.Time
+}
+
+func (w *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+	w.deadline = deadline
+	return nil
+}
+
+// TestMethodWriteDeadline verifies the route sets the write deadline before
+// its handler runs, and that writers without deadlines are still served.
+func TestMethodWriteDeadline(t *testing.T) {
+	m := New()
+	m.MethodWriteDeadline(http.MethodGet, `^/download$`, 5*time.Second, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	})
+
+	w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	start := time.Now()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/download", nil))
+	if w.deadline.Before(start.Add(5*time.Second)) || w.deadline.After(time.Now().Add(5*time.Second)) {
+		t.Fatalf("expected a deadline 5s after the request, got %v (started %v)", w.deadline, start)
+	}
+	if w.Body.String() != "data" {
+		t.Fatalf("expected the handler to run, got %q", w.Body.String())
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download", nil))
+	if rec.Body.String() != "data" {
+		t.Fatalf("expected a writer without deadlines to be served, got %q", rec.Body.String())
+	}
+}
+
+// TestMethodCanary verifies a seeded canary route splits requests roughly by
+// weight, and reproducibly.
+func TestMethodCanary(t *testing.T) {
+	split := func() map[string]int {
+		m := New(WithCanarySeed(42))
+		m.MethodCanary(http.MethodGet, `^/search$`, []WeightedHandler{
+			{Weight: 90, Handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("stable")) }},
+			{Weight: 10, Handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("canary")) }},
+		})
+		counts := make(map[string]int)
+		for range 10000 {
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search", nil))
+			counts[rec.Body.String()]++
+		}
+		return counts
+	}
+
+	counts := split()
+	if counts["canary"] < 800 || counts["canary"] > 1200 || counts["stable"]+counts["canary"] != 10000 {
+		t.Fatalf("expected about a 90/10 split, got %v", counts)
+	}
+	if again := split(); !maps.Equal(again, counts) {
+		t.Fatalf("expected the same seed to give the same split, got %v and %v", counts, again)
+	}
+}
+
+// TestSegments verifies a Segments route matches paths with enough segments,
+// exposing each segment and the rest of the path.
+func TestSegments(t *testing.T) {
+	m := New()
+	m.Segments(2, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s|%s", URLParamIndex(r, 0), URLParamIndex(r, 1), URLParam(r, RestParam))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "with remainder",
+			path:           "/a/b/c",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "a|b|/c",
+		}, {
+			name:           "exact segment count",
+			path:           "/a/b",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "a|b|",
+		}, {
+			name:           "too few segments",
+			path:           "/a",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		}, {
+			name:           "empty segment",
+			path:           "/a//c",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+}
+
+// TestRoutePrefix verifies a RoutePrefix mount serves the bare prefix through
+// a `^$` sub-route and deeper paths through the others.
+func TestRoutePrefix(t *testing.T) {
+	m := New()
+	m.RoutePrefix("/route1", func(r Router) {
+		r.Get(`^$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("index"))
+		})
+		r.Get(`^foo$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("foo"))
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "bare prefix",
+			path:           "/route1",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "index",
+		}, {
+			name:           "trailing slash",
+			path:           "/route1/",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "index",
+		}, {
+			name:           "sub-route",
+			path:           "/route1/foo",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "foo",
+		}, {
+			name:           "longer prefix",
+			path:           "/route10",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+}
+
+// TestCanceledStatus verifies requests whose context is done before dispatch
+// get the configured status, 503 by default, without reaching the route,
+// however the context was canceled, and that nothing is written when the
+// server has canceled it for a disconnected client.
+func TestCanceledStatus(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	caused, cancelCause := context.WithCancelCause(context.Background())
+	cancelCause(errors.New("shutting down"))
+	served := context.WithValue(context.Background(), http.ServerContextKey, &http.Server{})
+	disconnected, disconnect := context.WithCancel(served)
+	disconnect()
+
+	for _, code := range []int{0, 499} {
+		var opts []Option
+		if code != 0 {
+			opts = append(opts, WithCanceledStatus(code))
+		}
+		m := New(opts...)
+		m.Get(`^/report$`, func(w http.ResponseWriter, r *http.Request) {
+			t.Error("expected the route not to run for a canceled request")
+		})
+
+		want := code
+		if want == 0 {
+			want = http.StatusServiceUnavailable
+		}
+		for name, ctx := range map[string]context.Context{"cancel": canceled, "cause": caused} {
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/report", nil).WithContext(ctx))
+			if rec.Code != want {
+				t.Fatalf("status %d configured, %s: expected %d, got %d", code, name, want, rec.Code)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/report", nil).WithContext(disconnected))
+		if rec.Code != http.StatusOK || rec.Body.Len() != 0 || len(rec.Header()) != 0 {
+			t.Fatalf("status %d configured: expected nothing written on disconnect, got %d %q", code, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// TestAround verifies the Around wrapper sees the pattern of the matched
+// route, including one registered before the call, and wraps in order with
+// post-match middleware.
+func TestAround(t *testing.T) {
+	m := New()
+	m.Get(`^/users/(?P<id>\d+)$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user"))
+	})
+	m.UsePostMatch(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", "post-match")
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.Around(func(next http.Handler, pattern string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", "around")
+			w.Header().Set("X-Route", pattern)
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.Get(`^/health$`, func(w http.ResponseWriter, r *http.Request) {})
+
+	for path, want := range map[string]string{
+		"/users/7": `^/users/(?P<id>\d+)$`,
+		"/health":  `^/health$`,
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := rec.Header().Get("X-Route"); got != want {
+			t.Fatalf("%s: expected X-Route %q, got %q", path, want, got)
+		}
+		if got := rec.Header().Values("X-Order"); !slices.Equal(got, []string{"post-match", "around"}) {
+			t.Fatalf("%s: expected post-match then around, got %q", path, got)
+		}
+	}
+}
+
+// TestMethodDynamic verifies the factory picks a handler per request from the
+// captured parameters, and that a nil handler gets a 404.
+func TestMethodDynamic(t *testing.T) {
+	calls := 0
+	m := New()
+	m.MethodDynamic(http.MethodGet, `^/plugins/(?P<name>\w+)$`, func(params map[string]string) http.Handler {
+		calls++
+		switch params["name"] {
+		case "echo":
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("echo " + r.URL.Query().Get("q")))
+			})
+		case "time":
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("noon"))
+			})
+		}
+		return nil
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "echo plugin",
+			path:           "/plugins/echo?q=hi",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "echo hi",
+		}, {
+			name:           "time plugin",
+			path:           "/plugins/time",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "noon",
+		}, {
+			name:           "unknown plugin",
+			path:           "/plugins/nope",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		}, {
+			name:           "repeat request",
+			path:           "/plugins/time",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "noon",
+		},
+	})
+	if calls != 4 {
+		t.Fatalf("expected the factory to be called for every request, got %d calls", calls)
+	}
+}
+
+// TestURLParamNames verifies the names of the OCI manifest route's groups are
+// reported in pattern order, without its unnamed group.
+func TestURLParamNames(t *testing.T) {
+	m := New()
+	m.Get(`^/v2/(?P<name>[^/]+(?:/[^/]+)*)/manifests/(?P<reference>[^/]+)$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Join(URLParamNames(r), ",")))
+	})
+	m.Get(`^/healthz$`, func(w http.ResponseWriter, r *http.Request) {
+		if names := URLParamNames(r); names != nil {
+			t.Errorf("expected no names for a route without groups, got %q", names)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/library/ubuntu/manifests/latest", nil))
+	if rec.Body.String() != "name,reference" {
+		t.Fatalf("expected \"name,reference\", got %q", rec.Body.String())
+	}
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+}
+
+// TestHideMethodNotAllowed verifies a method mismatch gets a 404 without an
+// Allow header when enabled, for ordinary and Resource routes.
+func TestHideMethodNotAllowed(t *testing.T) {
+	m := New(WithHideMethodNotAllowed())
+	m.Get(`^/users$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users"))
+	})
+	m.Resource(`^/items$`, map[string]http.HandlerFunc{
+		http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("items"))
+		},
+	})
+
+	for _, path := range []string{"/users", "/items"} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+		if rec.Code != http.StatusNotFound || rec.Body.String() != "not found" {
+			t.Fatalf("%s: expected 404 \"not found\", got %d %q", path, rec.Code, rec.Body.String())
+		}
+		if allow := rec.Header().Get("Allow"); allow != "" {
+			t.Fatalf("%s: expected no Allow header, got %q", path, allow)
+		}
+
+		rec = httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected GET to be served, got %d", path, rec.Code)
+		}
+	}
+}
+
+// TestMatchN verifies MatchN reports the matched route's position and, in
+// first-match mode, that every route up to it was scanned.
+func TestMatchN(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	m := New()
+	m.Get(`^/a$`, h)
+	m.Get(`^/b$`, h)
+	m.Post(`^/c$`, h)
+	m.Get(`^/c$`, h)
+	m.Get(`^/d/(?P<id>\d+)$`, h)
+
+	for _, tc := range []struct {
+		method, path   string
+		index, scanned int
+	}{
+		{http.MethodGet, "/a", 0, 1},
+		{http.MethodGet, "/c", 2, 3},
+		{http.MethodGet, "/d/7", 3, 4},
+		{http.MethodGet, "/missing", -1, 4},
+		{http.MethodDelete, "/c", -1, 4},
+	} {
+		index, scanned := m.MatchN(tc.method, tc.path)
+		if index != tc.index || scanned != tc.scanned {
+			t.Errorf("MatchN(%s, %q): expected (%d, %d), got (%d, %d)", tc.method, tc.path, tc.index, tc.scanned, index, scanned)
+		}
+		if index >= 0 && scanned != index+1 {
+			t.Errorf("MatchN(%s, %q): expected scanned to be index+1, got (%d, %d)", tc.method, tc.path, index, scanned)
+		}
+	}
+}
+
+// BenchmarkRouteOrder shows the cost of a frequently requested route placed
+// after 200 others, against the same route placed first.
+func BenchmarkRouteOrder(b *testing.B) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	for _, first := range []bool{false, true} {
+		b.Run(fmt.Sprintf("hot-first=%t", first), func(b *testing.B) {
+			m := New()
+			if first {
+				m.Get(`^/hot$`, h)
+			}
+			for i := range 200 {
+				m.Get(fmt.Sprintf(`^/cold%d/(?P<id>\d+)$`, i), h)
+			}
+			if !first {
+				m.Get(`^/hot$`, h)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/hot", nil)
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			for b.Loop() {
+				req.Pattern = ""
+				m.ServeHTTP(w, req)
+			}
+			_, scanned := m.MatchN(http.MethodGet, "/hot")
+			b.ReportMetric(float64(scanned), "scanned/op")
+		})
+	}
+}
+
 func runTestCases(t *testing.T, ts *httptest.Server, testCases []testCase) {
 	for _, tc := range testCases {
 		resp, body := testRequest(t, ts, tc.method, tc.path, tc.body)
@@ -0,0 +1,140 @@
+package regexrouter
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp/syntax"
+	"strings"
+)
+
+// OpenAPIInfo is the info object of the document served by OpenAPIHandler.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIHandler returns a handler serving a minimal OpenAPI 3 document, as
+// JSON, describing the routes registered on mx. It is meant as a starting
+// point for tooling rather than a complete description:
+//
+//   - Patterns are converted to path templates, with named groups becoming
+//     `{name}` path parameters. Routes whose patterns contain anything else
+//     besides literals and anchors cannot be expressed and are left out.
+//   - Handle and HandleFunc registrations are left out, as OpenAPI has no way
+//     to say "every method".
+//   - The "summary" and "description" metadata of a route (see Mux.Meta), if
+//     strings, describe its operations.
+//
+// The document is rebuilt on every request, so it reflects routes added or
+// removed after the handler was created.
+func (mx *Mux) OpenAPIHandler(info OpenAPIInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc := openAPIDocument{OpenAPI: "3.0.3", Info: info, Paths: map[string]map[string]openAPIOperation{}}
+		for _, rt := range mx.Routes() {
+			path, params, ok := pathTemplate(rt.Pattern)
+			if !ok {
+				continue
+			}
+			for _, method := range rt.Methods {
+				if method == methodAll {
+					continue
+				}
+				op := openAPIOperation{
+					Responses: map[string]openAPIResponse{"default": {Description: "response"}},
+				}
+				op.Summary, _ = rt.Meta["summary"].(string)
+				op.Description, _ = rt.Meta["description"].(string)
+				for _, p := range params {
+					op.Parameters = append(op.Parameters, openAPIParameter{
+						Name: p, In: "path", Required: true, Schema: openAPISchema{Type: "string"},
+					})
+				}
+				if doc.Paths[path] == nil {
+					doc.Paths[path] = map[string]openAPIOperation{}
+				}
+				doc.Paths[path][strings.ToLower(method)] = op
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doc)
+	}
+}
+
+type openAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    OpenAPIInfo                            `json:"info"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required"`
+	Schema   openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type string `json:"type"`
+}
+
+type openAPIResponse struct {
+	Description string `json:"description"`
+}
+
+// pathTemplate converts a RouteInfo pattern, possibly a chain of sub-Router
+// patterns, to an OpenAPI path template and its parameter names. Each link's
+// SubrouteParam group stands for the rest of the chain. ok is false if a
+// pattern has anything but literals, anchors and named groups.
+func pathTemplate(chain string) (path string, params []string, ok bool) {
+	links := strings.Split(chain, routePatternSeparator)
+	path = "{" + SubrouteParam + "}"
+	for _, link := range links {
+		tmpl, linkParams, ok := patternTemplate(link)
+		if !ok {
+			return "", nil, false
+		}
+		path = strings.Replace(path, "{"+SubrouteParam+"}", tmpl, 1)
+		params = append(params, linkParams...)
+	}
+	return path, params, true
+}
+
+// patternTemplate converts one pattern to a path template; see pathTemplate.
+func patternTemplate(pattern string) (tmpl string, params []string, ok bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", nil, false
+	}
+	re = re.Simplify()
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	var b strings.Builder
+	for _, sub := range subs {
+		switch sub.Op {
+		case syntax.OpBeginLine, syntax.OpBeginText, syntax.OpEndLine, syntax.OpEndText, syntax.OpEmptyMatch:
+		case syntax.OpLiteral:
+			b.WriteString(string(sub.Rune))
+		case syntax.OpCapture:
+			if sub.Name == "" {
+				return "", nil, false
+			}
+			b.WriteString("{" + sub.Name + "}")
+			if sub.Name != SubrouteParam {
+				params = append(params, sub.Name)
+			}
+		default:
+			return "", nil, false
+		}
+	}
+	return b.String(), params, true
+}
@@ -0,0 +1,55 @@
+package regexrouter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestOpenAPIHandler verifies the document lists converted path templates with
+// their methods, parameters and descriptions, including sub-routes.
+func TestOpenAPIHandler(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	m := New()
+	m.Get(`^/users/(?P<id>[0-9]+)$`, h)
+	m.Meta("description", "Fetch a user")
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Post(`^widgets$`, h)
+	})
+	m.Get(`^/files/.*$`, h) // not expressible as a template
+	m.Get(`^/openapi\.json$`, m.OpenAPIHandler(OpenAPIInfo{Title: "test", Version: "1.0"}))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title string `json:"title"`
+		} `json:"info"`
+		Paths map[string]map[string]struct {
+			Description string `json:"description"`
+			Parameters  []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON document: %v\n%s", err, rec.Body.String())
+	}
+	if doc.OpenAPI == "" || doc.Info.Title != "test" {
+		t.Fatalf("missing document header: %s", rec.Body.String())
+	}
+	user, ok := doc.Paths["/users/{id}"]["get"]
+	if !ok || user.Description != "Fetch a user" || len(user.Parameters) != 1 || user.Parameters[0].Name != "id" {
+		t.Fatalf("expected GET /users/{id} with its parameter and description, got %s", rec.Body.String())
+	}
+	if _, ok := doc.Paths["/api/widgets"]["post"]; !ok {
+		t.Fatalf("expected POST /api/widgets from the sub-Router, got %s", rec.Body.String())
+	}
+	if len(doc.Paths) != 3 {
+		t.Fatalf("expected 3 paths (the unconvertible route left out), got %d", len(doc.Paths))
+	}
+}
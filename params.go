@@ -0,0 +1,71 @@
+package regexrouter
+
+import "context"
+
+// params holds everything the router records about a matched route for the
+// request: its captures, and the accessors' other inputs. ServeHTTP stores it
+// in the request context as a single value, rather than one value per
+// parameter, so dispatch costs one context allocation however many groups the
+// pattern has. A sub-Router's params link to those of the route it is
+// mounted on, keeping the enclosing parameters readable.
+type params struct {
+	// The route's capture group names, as from captureNames, and its
+	// FindStringSubmatch result: the matched text, then each group.
+	names   []string
+	matches []string
+
+	// Parameters not captured by the pattern: query parameters under
+	// WithCaptureQuery, or those given to GetWithParams.
+	extra map[string]string
+
+	viaAny bool
+	name   string
+	meta   map[string]any
+
+	// The route table the route was matched in; nil for query parameters.
+	table *routes
+
+	// The params of the enclosing route, or nil.
+	parent *params
+}
+
+// paramsFromCtx returns the params of the innermost matched route, or nil.
+func paramsFromCtx(ctx context.Context) *params {
+	p, _ := ctx.Value(ctxKeyParams).(*params)
+	return p
+}
+
+// get returns the named parameter, looking in enclosing routes' params when
+// p has no parameter of that name.
+func (p *params) get(name string) (string, bool) {
+	for ; p != nil; p = p.parent {
+		for i, n := range p.names {
+			if n == name && i+1 < len(p.matches) {
+				return p.matches[i+1], true
+			}
+		}
+		if v, ok := p.extra[name]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// all returns every named parameter visible through p, inner routes' values
+// taking precedence.
+func (p *params) all() map[string]string {
+	all := make(map[string]string)
+	for ; p != nil; p = p.parent {
+		for name, v := range p.extra {
+			if _, ok := all[name]; !ok {
+				all[name] = v
+			}
+		}
+		for i, name := range p.names {
+			if _, ok := all[name]; !ok && name != "" && i+1 < len(p.matches) {
+				all[name] = p.matches[i+1]
+			}
+		}
+	}
+	return all
+}
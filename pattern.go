@@ -0,0 +1,133 @@
+package regexrouter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pattern translates a path template into an anchored route pattern, for
+// routes that need no regex beyond named segments. A `{name}` placeholder
+// matches one path segment and becomes the named group "name"; `{name?}`
+// makes the segment, together with its leading slash, optional. A constraint
+// regex may follow the name, `{name:regex}` (or `{name?:regex}`), in place of
+// the default one-segment match. All other text is matched literally:
+//
+//	m.Get(m.Pattern("/users/{id}"), h)      // ^/users/(?P<id>[^/]+)$
+//	m.Get(m.Pattern("/users/{id?}"), h)     // ^/users(?:/(?P<id>[^/]+))?$
+//	m.Get(m.Pattern(`/users/{id:\d+}`), h) // ^/users/(?P<id>\d+)$
+//
+// Pattern panics on a malformed template, as registration does on an invalid
+// pattern.
+func (mx *Mux) Pattern(tmpl string) string {
+	pattern, err := compileTemplate(tmpl)
+	if err != nil {
+		panic(fmt.Sprintf("regexrouter: invalid path template %q: %v", tmpl, err))
+	}
+	return pattern
+}
+
+// FromChi converts a chi route pattern into an anchored pattern for this
+// router. `{name}` and `{name:regex}` become named groups as in Pattern, and a
+// trailing `*` wildcard becomes the SubrouteParam group, so the result can be
+// passed straight to Route:
+//
+//	FromChi("/users/{id}")        // ^/users/(?P<id>[^/]+)$
+//	FromChi(`/users/{id:[0-9]+}`) // ^/users/(?P<id>[0-9]+)$
+//	FromChi("/static/*")          // ^/static/(?P<subroute>.*)$
+//
+// FromChi panics on a malformed pattern.
+func FromChi(pattern string) string {
+	tmpl, wildcard := strings.CutSuffix(pattern, "*")
+	re, err := compileTemplate(tmpl)
+	if err != nil {
+		panic(fmt.Sprintf("regexrouter: invalid chi pattern %q: %v", pattern, err))
+	}
+	if wildcard {
+		re = strings.TrimSuffix(re, "$") + "(?P<" + SubrouteParam + ">.*)$"
+	}
+	return re
+}
+
+// segmentPattern is what a placeholder matches: one non-empty path segment.
+const segmentPattern = `[^/]+`
+
+// compileTemplate does the work of Pattern, returning an error instead of
+// panicking.
+func compileTemplate(tmpl string) (string, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	rest := tmpl
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			b.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		end := placeholderEnd(rest[open:])
+		if end < 0 {
+			return "", fmt.Errorf("unclosed '{' at offset %d", len(tmpl)-len(rest)+open)
+		}
+		literal, placeholder := rest[:open], rest[open+1:open+end]
+		rest = rest[open+end+1:]
+
+		name, constraint, hasConstraint := strings.Cut(placeholder, ":")
+		if !hasConstraint {
+			constraint = segmentPattern
+		} else if _, err := regexp.Compile(constraint); err != nil {
+			return "", fmt.Errorf("invalid constraint for %q: %v", name, err)
+		}
+		name, optional := strings.CutSuffix(name, "?")
+		if !validGroupName(name) {
+			return "", fmt.Errorf("invalid parameter name %q", name)
+		}
+		group := fmt.Sprintf("(?P<%s>%s)", name, constraint)
+		if optional {
+			// Make the separating slash part of the optional group so the
+			// bare prefix (no trailing slash) matches too.
+			literal, slash := strings.CutSuffix(literal, "/")
+			b.WriteString(regexp.QuoteMeta(literal))
+			if slash {
+				group = "/" + group
+			}
+			b.WriteString("(?:" + group + ")?")
+			continue
+		}
+		b.WriteString(regexp.QuoteMeta(literal))
+		b.WriteString(group)
+	}
+	b.WriteString("$")
+	return b.String(), nil
+}
+
+// placeholderEnd returns the index of the '}' closing the placeholder that s
+// starts with, or -1 if it is unclosed. Nested braces are balanced.
+func placeholderEnd(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// validGroupName reports whether name can be used as a regexp capture group
+// name.
+func validGroupName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
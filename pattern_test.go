@@ -0,0 +1,138 @@
+package regexrouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPatternTemplate verifies {name} and {name?} placeholders compile to
+// anchored patterns with named groups, and that the results route correctly.
+func TestPatternTemplate(t *testing.T) {
+	m := New()
+	for tmpl, want := range map[string]string{
+		"/users/{id}":  `^/users/(?P<id>[^/]+)$`,
+		"/users/{id?}": `^/users(?:/(?P<id>[^/]+))?$`,
+		"/a.b/{x}":     `^/a\.b/(?P<x>[^/]+)$`,
+	} {
+		if got := m.Pattern(tmpl); got != want {
+			t.Fatalf("Pattern(%q) = %q, want %q", tmpl, got, want)
+		}
+	}
+
+	m.Get(m.Pattern("/users/{id}"), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + URLParam(r, "id")))
+	})
+	m.Get(m.Pattern("/teams/{id?}"), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("team " + URLParam(r, "id")))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "required segment",
+			path:           "/users/42",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "user 42",
+		}, {
+			name:           "required segment missing",
+			path:           "/users/",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		}, {
+			name:           "optional segment present",
+			path:           "/teams/7",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "team 7",
+		}, {
+			name:           "optional segment absent",
+			path:           "/teams",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "team ",
+		},
+	})
+}
+
+// TestPatternTemplateInvalid verifies a malformed template panics.
+func TestPatternTemplateInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected an unclosed placeholder to panic")
+		}
+	}()
+	New().Pattern("/users/{id")
+}
+
+// TestPatternTemplateConstraint verifies a {name:regex} constraint replaces the
+// default segment match, so non-conforming input is rejected.
+func TestPatternTemplateConstraint(t *testing.T) {
+	m := New()
+	if got, want := m.Pattern(`/users/{id:\d+}`), `^/users/(?P<id>\d+)$`; got != want {
+		t.Fatalf("Pattern = %q, want %q", got, want)
+	}
+	if got, want := m.Pattern(`/v{major:[0-9]{1,2}}/x`), `^/v(?P<major>[0-9]{1,2})/x$`; got != want {
+		t.Fatalf("Pattern with nested braces = %q, want %q", got, want)
+	}
+
+	m.Get(m.Pattern(`/users/{id:\d+}`), func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + URLParam(r, "id")))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "constraint satisfied",
+			path:           "/users/42",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "user 42",
+		}, {
+			name:           "constraint rejected",
+			path:           "/users/abc",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found",
+		},
+	})
+}
+
+// TestFromChi verifies conversion of chi params, constrained params and the
+// trailing wildcard, and that the wildcard works as a Route remainder.
+func TestFromChi(t *testing.T) {
+	for chi, want := range map[string]string{
+		"/users/{id}":          `^/users/(?P<id>[^/]+)$`,
+		"/users/{id:[0-9]+}":   `^/users/(?P<id>[0-9]+)$`,
+		"/static/*":            `^/static/(?P<subroute>.*)$`,
+		"/{org}/repos/{repo}/": `^/(?P<org>[^/]+)/repos/(?P<repo>[^/]+)/$`,
+	} {
+		if got := FromChi(chi); got != want {
+			t.Fatalf("FromChi(%q) = %q, want %q", chi, got, want)
+		}
+	}
+
+	m := New()
+	m.Route(FromChi("/api/{version:v[0-9]+}/*"), func(r Router) {
+		r.Get(`^widgets$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(URLParam(r, "version") + " widgets"))
+		})
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{{
+		name:           "chi wildcard mounts a sub-Router",
+		path:           "/api/v2/widgets",
+		method:         http.MethodGet,
+		expectedStatus: http.StatusOK,
+		expectedBody:   "v2 widgets",
+	}})
+}
@@ -45,7 +45,8 @@ import (
 type Router interface {
 	http.Handler
 
-	// Use appends one or more middlewares onto the Router stack.
+	// Use appends one or more middlewares onto the Router stack. They wrap
+	// routes registered after the call, not those registered before it.
 	Use(middlewares ...func(http.Handler) http.Handler)
 
 	// With adds inline middlewares for an endpoint handler.
@@ -55,13 +56,17 @@ type Router interface {
 	// path, with a fresh middleware stack for the inline-Router.
 	Group(fn func(r Router)) Router
 
+	// Prefix adds an inline-Router, like Group, on which every pattern is
+	// registered with prefix literally prepended.
+	Prefix(prefix string, fn func(r Router))
+
 	// Route mounts a sub-Router along a `pattern`` string. It is the way to
 	// compose sub-Routers; use a `(?P<subroute>...)` capture group in the
 	// pattern to delegate the remaining path to the sub-Router.
 	Route(pattern string, fn func(r Router)) Router
 
 	// Handle and HandleFunc adds routes for `pattern` that matches
-	// all HTTP methods.
+	// all HTTP methods, or only the one set by WithDefaultMethod.
 	Handle(pattern string, h http.Handler)
 	HandleFunc(pattern string, h http.HandlerFunc)
 
@@ -70,6 +75,11 @@ type Router interface {
 	Method(method, pattern string, h http.Handler)
 	MethodFunc(method, pattern string, h http.HandlerFunc)
 
+	// MethodE and GetE add routes served by an error-returning handler,
+	// whose non-nil error goes to the error handler (see WithErrorHandler).
+	MethodE(method, pattern string, h HandlerFuncE)
+	GetE(pattern string, h HandlerFuncE)
+
 	// HTTP-method routing along `pattern`
 	Connect(pattern string, h http.HandlerFunc)
 	Delete(pattern string, h http.HandlerFunc)
@@ -90,6 +100,47 @@ type Router interface {
 	MethodNotAllowed(h http.HandlerFunc)
 }
 
+// Middleware is a standard net/http middleware: it wraps a handler in another.
+type Middleware = func(http.Handler) http.Handler
+
 // Middlewares type is a slice of standard middleware handlers with methods
 // to compose middleware chains and http.Handler's.
 type Middlewares []func(http.Handler) http.Handler
+
+// WeightedHandler is a handler with a relative weight, one of the handlers
+// among which Mux.MethodCanary splits a route's requests.
+type WeightedHandler struct {
+	Weight  int
+	Handler http.HandlerFunc
+}
+
+// HandlerFuncE is an http.HandlerFunc that returns an error. Register one with
+// Mux.GetE (or MethodE); a non-nil error is passed to the error handler set by
+// WithErrorHandler.
+type HandlerFuncE func(http.ResponseWriter, *http.Request) error
+
+// Handler adapts fn, which takes request parameters as a typed value T, to an
+// http.HandlerFunc. extract builds the T from the request, typically with
+// URLParam; if it returns an error the client gets a 400 Bad Request and fn
+// is not called:
+//
+//	type userParams struct{ ID int }
+//
+//	m.Get(`^/users/(?P<id>[0-9]+)$`, regexrouter.Handler(
+//		func(r *http.Request) (userParams, error) {
+//			id, err := strconv.Atoi(regexrouter.URLParam(r, "id"))
+//			return userParams{ID: id}, err
+//		},
+//		func(w http.ResponseWriter, r *http.Request, p userParams) { ... },
+//	))
+func Handler[T any](extract func(*http.Request) (T, error), fn func(http.ResponseWriter, *http.Request, T)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := extract(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("bad request"))
+			return
+		}
+		fn(w, r, params)
+	}
+}
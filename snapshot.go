@@ -0,0 +1,78 @@
+package regexrouter
+
+import "maps"
+
+// RouteTable is a copy of a Mux's routes, taken by Snapshot and installed by
+// Restore. Its zero value is an empty table.
+type RouteTable struct {
+	rts         []route
+	conditional bool
+}
+
+// Snapshot returns a copy of the routes registered on mx (for an inline mux,
+// of the table it registers into). Later registrations on mx do not change
+// the copy. Mounted sub-Routers are not copied: the snapshot refers to them
+// as they are.
+func (mx *Mux) Snapshot() RouteTable {
+	t := mx.table()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return RouteTable{rts: cloneRoutes(t.rts), conditional: t.conditional}
+}
+
+// Restore replaces the routes of mx with those of rt in one step, so requests
+// served concurrently see either the old routes or the new ones, never a mix.
+// For hot reloading, build the new routes on a separate Mux and restore its
+// Snapshot onto the serving one:
+//
+//	next := regexrouter.New()
+//	next.Get(`^/v2/status$`, status)
+//	live.Restore(next.Snapshot())
+//
+// Handlers keep the middleware they were registered with, and sub-Routers
+// mounted on the other Mux keep falling back to its NotFound and
+// MethodNotAllowed handlers.
+func (mx *Mux) Restore(rt RouteTable) {
+	rts := cloneRoutes(rt.rts)
+	t := mx.table()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rts, t.conditional = rts, rt.conditional
+	t.cache.clear()
+	t.trie = nil
+}
+
+// cloneRoutes copies rts deeply enough that registering on, or removing from,
+// either copy leaves the other unchanged.
+func cloneRoutes(rts []route) []route {
+	clone := make([]route, len(rts))
+	for i, rt := range rts {
+		rt.methodhandler = maps.Clone(rt.methodhandler)
+		rt.middleware = maps.Clone(rt.middleware)
+		rt.meta = maps.Clone(rt.meta)
+		clone[i] = rt
+	}
+	return clone
+}
+
+// Reset removes every route registered on mx (for an inline mux, from the
+// table it registers into), the middleware added to mx with Use, UseNamed,
+// UsePostMatch and Around, and the hooks added with After, so a Mux can be
+// reused, for example between test cases. Options and the NotFound,
+// MethodNotAllowed and other fallback handlers are kept. Sub-Routers mounted
+// on mx are dropped along with their mounts.
+//
+// Like Use and After, Reset must not be called while mx is serving requests.
+// To swap the routes of a live Mux, Restore a table built on another one
+// instead; Restore(RouteTable{}) empties it.
+func (mx *Mux) Reset() {
+	t := mx.table()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rts, t.conditional = nil, false
+	t.cache.clear()
+	t.trie = nil
+	mx.middlewares, mx.middlewareNames, mx.postMatch = nil, nil, nil
+	mx.afterHooks = nil
+	mx.lastPattern = ""
+}
@@ -0,0 +1,100 @@
+package regexrouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestRestore verifies restoring a table built on another Mux swaps the
+// routes while requests are being served, and that a snapshot can roll the
+// change back.
+func TestRestore(t *testing.T) {
+	reply := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}
+	}
+	live := New()
+	live.Get(`^/v1/status$`, reply("v1"))
+	old := live.Snapshot()
+
+	next := New()
+	next.Get(`^/v2/status$`, reply("v2"))
+
+	// Serve concurrently with the swap: every request sees a whole table.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			rec := httptest.NewRecorder()
+			live.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/status", nil))
+			if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+				t.Errorf("unexpected status %d during swap", rec.Code)
+			}
+		}
+	}()
+	live.Restore(next.Snapshot())
+	close(stop)
+	wg.Wait()
+
+	status := func(path string) int {
+		rec := httptest.NewRecorder()
+		live.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	if status("/v1/status") != http.StatusNotFound || status("/v2/status") != http.StatusOK {
+		t.Fatal("expected old routes gone and new ones served after Restore")
+	}
+
+	live.Restore(old)
+	if status("/v1/status") != http.StatusOK || status("/v2/status") != http.StatusNotFound {
+		t.Fatal("expected the snapshot restored")
+	}
+}
+
+// TestReset verifies Reset drops routes, middleware and After hooks but keeps
+// the NotFound handler, and that the mux can be reused.
+func TestReset(t *testing.T) {
+	m := New()
+	m.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("custom not found"))
+	})
+	m.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Old", "1")
+			next.ServeHTTP(w, r)
+		})
+	})
+	m.Get(`^/old$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("old"))
+	})
+	m.After(func(r *http.Request) {
+		t.Error("expected the After hook to be reset")
+	})
+
+	m.Reset()
+	m.Get(`^/new$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/old", nil))
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "custom not found" {
+		t.Fatalf("expected the kept NotFound handler for a reset route, got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/new", nil))
+	if rec.Body.String() != "new" || rec.Header().Get("X-Old") != "" {
+		t.Fatalf("expected the new route without the reset middleware, got %q (X-Old %q)", rec.Body.String(), rec.Header().Get("X-Old"))
+	}
+}
@@ -0,0 +1,83 @@
+package regexrouter
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Suggest returns up to max patterns registered on mx that path does not
+// match but comes close to, closest first, for "did you mean" hints in a
+// NotFound handler. Closeness is the edit distance between path and the
+// literal text a pattern starts with, such as "/users/" for
+// `^/users/(?P<id>\d+)$`; when the pattern has more after that text, only as
+// much of path is compared. Patterns further than a third of the length of
+// their literal text are not suggested. Routes of mounted sub-Routers are not
+// considered.
+func (mx *Mux) Suggest(path string, max int) []string {
+	if max <= 0 {
+		return nil
+	}
+
+	t := mx.table()
+	t.mu.RLock()
+	rts := slices.Clone(t.rts)
+	t.mu.RUnlock()
+
+	type suggestion struct {
+		pattern  string
+		distance int
+	}
+	var suggestions []suggestion
+	for _, rt := range rts {
+		if rt.matchString(path) {
+			continue
+		}
+		literal, complete := rt.regex.LiteralPrefix()
+		literal = rt.literal + literal
+		if literal == "" {
+			continue
+		}
+		compared := path
+		if !complete && len(compared) > len(literal) {
+			compared = compared[:len(literal)]
+		}
+		if d := editDistance(compared, literal); d <= len(literal)/3 {
+			suggestions = append(suggestions, suggestion{rt.pattern, d})
+		}
+	}
+
+	// Stable, so equally close patterns keep their matching order.
+	slices.SortStableFunc(suggestions, func(a, b suggestion) int {
+		return cmp.Compare(a.distance, b.distance)
+	})
+	var patterns []string
+	for _, s := range suggestions {
+		if len(patterns) == max {
+			break
+		}
+		patterns = append(patterns, s.pattern)
+	}
+	return patterns
+}
+
+// editDistance returns the Levenshtein distance between a and b, counted in
+// bytes.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
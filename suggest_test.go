@@ -0,0 +1,35 @@
+package regexrouter
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// TestSuggest verifies Suggest ranks close patterns first, skips distant and
+// matching ones, and honors max.
+func TestSuggest(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	m := New()
+	m.Get(`^/orders$`, h)
+	m.Get(`^/users$`, h)
+	m.Get(`^/users/(?P<id>\d+)$`, h)
+	m.Get(`^/healthz$`, h)
+
+	for _, tc := range []struct {
+		path string
+		max  int
+		want []string
+	}{
+		{"/usres", 3, []string{`^/users$`}},
+		{"/userz/42", 3, []string{`^/users/(?P<id>\d+)$`}},
+		{"/usres/42", 1, []string{`^/users/(?P<id>\d+)$`}},
+		{"/users", 3, []string{`^/users/(?P<id>\d+)$`}},
+		{"/completely/unrelated", 3, nil},
+		{"/usres", 0, nil},
+	} {
+		if got := m.Suggest(tc.path, tc.max); !slices.Equal(got, tc.want) {
+			t.Errorf("Suggest(%q, %d): expected %q, got %q", tc.path, tc.max, tc.want, got)
+		}
+	}
+}
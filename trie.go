@@ -0,0 +1,174 @@
+package regexrouter
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// prefixTrie is a radix tree over the literal text the routes of a table
+// start with (see splitLiteral), such as "/api/v1/users/" for
+// `^/api/v1/users/(?P<id>\d+)$`, so matching a path only runs the patterns
+// of the routes along the path's branch, and each of those only on the rest
+// of the path. Routes without literal text, including unanchored ones, are
+// kept at the root and always tried. It is built from the table on demand and
+// discarded whenever routes are added or removed.
+type prefixTrie struct {
+	root trieNode
+}
+
+type trieNode struct {
+	// The text on the edge from the parent node.
+	label string
+
+	// Indexes into the route table of the routes whose literal text ends
+	// at this node, in table order.
+	routes []int
+
+	children []*trieNode
+}
+
+func newPrefixTrie(rts []route) *prefixTrie {
+	t := &prefixTrie{}
+	for i, rt := range rts {
+		t.root.insert(rt.literal, i)
+	}
+	return t
+}
+
+// insert adds route i, whose literal text continues with s below n.
+func (n *trieNode) insert(s string, i int) {
+	for s != "" {
+		j := n.childIndex(s[0])
+		if j < 0 {
+			n.children = append(n.children, &trieNode{label: s, routes: []int{i}})
+			return
+		}
+		child := n.children[j]
+		common := 0
+		for common < len(child.label) && common < len(s) && child.label[common] == s[common] {
+			common++
+		}
+		if common < len(child.label) {
+			// Split the edge where s leaves it.
+			mid := &trieNode{label: child.label[:common], children: []*trieNode{child}}
+			child.label = child.label[common:]
+			n.children[j] = mid
+			child = mid
+		}
+		n, s = child, s[common:]
+	}
+	n.routes = append(n.routes, i)
+}
+
+func (n *trieNode) childIndex(c byte) int {
+	for j, child := range n.children {
+		if child.label[0] == c {
+			return j
+		}
+	}
+	return -1
+}
+
+// candidates appends to lists the route lists of the nodes along the branch
+// path follows, each in table order, and returns the result; see
+// nextCandidate.
+func (t *prefixTrie) candidates(path string, lists [][]int) [][]int {
+	n := &t.root
+	for {
+		if len(n.routes) > 0 {
+			lists = append(lists, n.routes)
+		}
+		if path == "" {
+			return lists
+		}
+		j := n.childIndex(path[0])
+		if j < 0 || !strings.HasPrefix(path, n.children[j].label) {
+			return lists
+		}
+		n = n.children[j]
+		path = path[len(n.label):]
+	}
+}
+
+// nextCandidate removes and returns the lowest route index at the head of
+// lists, merging them into table order, or returns -1 once they are empty.
+func nextCandidate(lists [][]int) int {
+	min := -1
+	for j, l := range lists {
+		if len(l) > 0 && (min < 0 || l[0] < lists[min][0]) {
+			min = j
+		}
+	}
+	if min < 0 {
+		return -1
+	}
+	i := lists[min][0]
+	lists[min] = lists[min][1:]
+	return i
+}
+
+// splitLiteral splits re, if it is anchored at the start of the text and
+// begins with literal text, into that text and a pattern compiled from the
+// rest, anchored in turn: `^/users/(?P<id>\d+)$` becomes "/users/" and
+// `^(?P<id>\d+)$`. The rest keeps the capture groups of re. Otherwise, or
+// if the rest looks back at the text before it (see looksBehind), it
+// returns "" and re.
+func splitLiteral(re *regexp.Regexp) (string, *regexp.Regexp) {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil || parsed.Op != syntax.OpConcat || len(parsed.Sub) < 2 || parsed.Sub[0].Op != syntax.OpBeginText {
+		return "", re
+	}
+	var literal []rune
+	rest := parsed.Sub[1:]
+	for len(rest) > 0 && rest[0].Op == syntax.OpLiteral && rest[0].Flags&syntax.FoldCase == 0 {
+		literal = append(literal, rest[0].Rune...)
+		rest = rest[1:]
+	}
+	if len(literal) == 0 || slices.ContainsFunc(rest, looksBehind) {
+		return "", re
+	}
+	parsed.Sub = append([]*syntax.Regexp{parsed.Sub[0]}, rest...)
+	suffix, err := regexp.Compile(parsed.String())
+	if err != nil || suffix.NumSubexp() != re.NumSubexp() {
+		return "", re
+	}
+	return string(literal), suffix
+}
+
+// looksBehind reports whether re contains an assertion that depends on the
+// character before its position, such as \b, which would see the start of
+// the text instead of the literal text once split off.
+func looksBehind(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginLine, syntax.OpBeginText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	}
+	return slices.ContainsFunc(re.Sub, looksBehind)
+}
+
+// index returns the trie for the table, building it if needed. The caller
+// must hold mu for reading.
+func (r *routes) index() *prefixTrie {
+	r.trieMu.Lock()
+	defer r.trieMu.Unlock()
+	if r.trie == nil {
+		r.trie = newPrefixTrie(r.rts)
+	}
+	return r.trie
+}
+
+// WithTrieIndex stores the routes of the router as a radix tree keyed by the
+// literal text at the start of each pattern anchored with "^", for large
+// tables of routes sharing prefixes, such as "/api/v1/". Each route keeps
+// only a pattern compiled from the rest of its pattern, after that text, which
+// takes much less memory than the whole pattern, and a request only runs the
+// patterns of the routes whose literal text its path begins with, on the rest
+// of its path. Matching order and semantics are those of the flat table.
+// Patterns registered with MethodRegexp are split and compiled again like
+// the others. Each sub-Router mounted by Route gets a tree of its own. The
+// tree is rebuilt on the first request after routes are added or removed.
+func WithTrieIndex() Option {
+	return func(mx *Mux) { mx.routes.indexed = true }
+}
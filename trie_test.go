@@ -0,0 +1,131 @@
+package regexrouter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"testing"
+)
+
+// TestTrieIndex verifies an indexed router answers like a flat one, keeping
+// registration order across shared prefixes, trying unanchored and
+// alternated patterns for every path and keeping word boundaries after the
+// literal text.
+func TestTrieIndex(t *testing.T) {
+	build := func(opts ...Option) *Mux {
+		m := New(opts...)
+		reply := func(body string) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(body)) }
+		}
+		m.Get(`^/api/v1/users/(?P<id>\d+)$`, reply("user"))
+		m.Get(`^/api/v1/users/me$`, reply("shadowed"))
+		m.Get(`^/api/v1/(?P<rest>.*)$`, reply("v1"))
+		m.Post(`^/api/v2/items$`, reply("post item"))
+		m.Get(`\.json$`, reply("json"))
+		m.Get(`^/a|^/b`, reply("alternation"))
+		m.Get(`(?i)^/Docs$`, reply("docs"))
+		m.Get(`^/files/a\.b`, reply("file"))
+		m.Get(`^/word\b`, reply("word"))
+		m.Get(`^/nonword\B`, reply("nonword"))
+		m.MethodRegexp(http.MethodGet, regexp.MustCompile(`^/compiled/(?P<x>\w+)$`), reply("compiled"))
+		m.Route(`^/admin(?P<subroute>/.*)$`, func(r Router) {
+			r.Get(`^/stats$`, reply("stats"))
+			r.Get(`^/s`, reply("s"))
+		})
+		return m
+	}
+	flat, indexed := build(), build(WithTrieIndex())
+
+	for _, path := range []string{
+		"/api/v1/users/7", "/api/v1/users/me", "/api/v1/other", "/api/v2/items",
+		"/x/data.json", "/api/v2/items.json", "/a", "/b/c", "/DOCS", "/docs",
+		"/admin/stats", "/admin/s", "/admin/x", "/", "",
+		"/files/a.b/c", "/files/axb", "/compiled/x", "/compiled/",
+		"/word/x", "/wordx", "/nonword/x", "/nonwordx",
+	} {
+		serve := func(m *Mux) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = path
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+			return rec
+		}
+		want, got := serve(flat), serve(indexed)
+		if got.Code != want.Code || got.Body.String() != want.Body.String() || got.Header().Get("Allow") != want.Header().Get("Allow") {
+			t.Errorf("%q: expected %d %q (Allow %q), got %d %q (Allow %q)", path,
+				want.Code, want.Body.String(), want.Header().Get("Allow"),
+				got.Code, got.Body.String(), got.Header().Get("Allow"))
+		}
+	}
+}
+
+// TestTrieIndexMemory verifies a table of 5000 routes sharing a prefix takes
+// less memory with the index than as a flat list.
+func TestTrieIndexMemory(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	heap := func(opts ...Option) uint64 {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		m := New(opts...)
+		for i := range 5000 {
+			m.Get(fmt.Sprintf(`^/api/v1/service%d/items/(?P<id>\d+)$`, i), h)
+		}
+		// Build the index.
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/service1/items/1", nil))
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		runtime.KeepAlive(m)
+		return after.HeapAlloc - before.HeapAlloc
+	}
+
+	flat, indexed := heap(), heap(WithTrieIndex())
+	t.Logf("flat: %d bytes, indexed: %d bytes", flat, indexed)
+	if indexed >= flat*3/4 {
+		t.Fatalf("expected the index to save at least a quarter of the flat table's %d bytes, got %d", flat, indexed)
+	}
+}
+
+// BenchmarkTrieIndex compares matching, and building the table and serving
+// a first request, for 5000 routes sharing prefixes, with and without the
+// index.
+func BenchmarkTrieIndex(b *testing.B) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	build := func(opts ...Option) *Mux {
+		m := New(opts...)
+		for i := range 5000 {
+			m.Get(fmt.Sprintf(`^/api/v1/service%d/items/(?P<id>\d+)$`, i), h)
+		}
+		return m
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/service4999/items/42", nil)
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"flat", nil},
+		{"trie", []Option{WithTrieIndex()}},
+	} {
+		b.Run(tc.name+"/match", func(b *testing.B) {
+			m := build(tc.opts...)
+			w := httptest.NewRecorder()
+			m.ServeHTTP(w, req) // builds the index
+			b.ReportAllocs()
+			for b.Loop() {
+				req.Pattern = ""
+				m.ServeHTTP(w, req)
+			}
+		})
+		b.Run(tc.name+"/build", func(b *testing.B) {
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			for b.Loop() {
+				req.Pattern = ""
+				build(tc.opts...).ServeHTTP(w, req)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package regexrouter
+
+import (
+	"maps"
+	"slices"
+)
+
+// RouteInfo describes a registered route, as reported by Routes and Walk.
+type RouteInfo struct {
+	// Pattern is the route's pattern. For a route inside a sub-Router it is
+	// the chain of patterns from the root, joined like http.Request.Pattern
+	// (for example "^/api/(?P<subroute>.*)$ > ^widgets$").
+	Pattern string
+
+	// Methods lists the methods the route has handlers for, sorted; "*"
+	// stands for a Handle or HandleFunc registration. Variant handlers (see
+	// ContextWithVariant) are not listed.
+	Methods []string
+
+	// Name is the route's name (see Mux.Name), or that of the innermost
+	// named sub-Router mount it is under.
+	Name string
+
+	// Meta holds the metadata attached with Mux.Meta, or is nil.
+	Meta map[string]any
+}
+
+// RouteEventKind is the kind of change a RouteEvent reports.
+type RouteEventKind int
+
+const (
+	// RouteAdded reports a handler registered for a method on a pattern,
+	// whether or not it replaced an earlier one.
+	RouteAdded RouteEventKind = iota
+
+	// RouteRemoved reports a handler removed with Mux.Remove.
+	RouteRemoved
+)
+
+// RouteEvent describes a change to a route table, as reported to the
+// functions passed to OnRouteChange.
+type RouteEvent struct {
+	Kind RouteEventKind
+
+	// Method is the method of the handler added or removed; "*" stands for
+	// a Handle or HandleFunc registration.
+	Method string
+
+	// Pattern is the route's pattern, as in the table it belongs to.
+	Pattern string
+}
+
+// OnRouteChange calls fn, synchronously, after each handler registered on or
+// removed from the route table of mx (for an inline mux, the table it
+// registers into), for example to keep a dashboard of the routes current.
+// Mounting a sub-Router registers a "*" handler on its pattern; routes within
+// the sub-Router are reported to the functions passed to its own
+// OnRouteChange. Replacing the whole table with Restore or Reset is not
+// reported.
+func (mx *Mux) OnRouteChange(fn func(event RouteEvent)) {
+	t := mx.table()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.observers = append(t.observers, fn)
+}
+
+// notify calls the functions passed to OnRouteChange with ev. The caller must
+// not hold mu.
+func (t *routes) notify(ev RouteEvent) {
+	t.mu.RLock()
+	observers := t.observers
+	t.mu.RUnlock()
+	for _, fn := range observers {
+		fn(ev)
+	}
+}
+
+// Routes returns a description of every route registered on mx, in matching
+// order, with the routes of mounted sub-Routers in place of their mounts.
+func (mx *Mux) Routes() []RouteInfo {
+	var infos []RouteInfo
+	mx.walk("", "", func(info RouteInfo) error {
+		infos = append(infos, info)
+		return nil
+	})
+	return infos
+}
+
+// Walk calls fn for each route registered on mx, in the order and form
+// reported by Routes, stopping at and returning the first error fn returns.
+func (mx *Mux) Walk(fn func(info RouteInfo) error) error {
+	return mx.walk("", "", fn)
+}
+
+func (mx *Mux) walk(prefix, name string, fn func(RouteInfo) error) error {
+	t := mx.table()
+	t.mu.RLock()
+	rts := slices.Clone(t.rts)
+	t.mu.RUnlock()
+
+	for _, rt := range rts {
+		pattern := rt.pattern
+		if prefix != "" {
+			pattern = prefix + routePatternSeparator + pattern
+		}
+		routeName := name
+		if rt.name != "" {
+			routeName = rt.name
+		}
+		if rt.subrouter != nil {
+			if err := rt.subrouter.walk(pattern, routeName, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		err := fn(RouteInfo{
+			Pattern: pattern,
+			Methods: rt.methods(),
+			Name:    routeName,
+			Meta:    maps.Clone(rt.meta),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package regexrouter
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// TestRoutes verifies Routes reports patterns, methods, names and metadata,
+// flattening sub-Routers, and that Walk stops at the first error.
+func TestRoutes(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	m := New()
+	m.Get(`^/users$`, h)
+	m.Post(`^/users$`, h)
+	m.Name("users")
+	m.Meta("description", "List or create users")
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.HandleFunc(`^widgets$`, h)
+	})
+
+	routes := m.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d: %+v", len(routes), routes)
+	}
+	users := routes[0]
+	if users.Pattern != `^/users$` || !slices.Equal(users.Methods, []string{"GET", "POST"}) || users.Name != "users" {
+		t.Fatalf("unexpected route info %+v", users)
+	}
+	if users.Meta["description"] != "List or create users" {
+		t.Fatalf("expected metadata to be reported, got %v", users.Meta)
+	}
+	if widgets := routes[1]; widgets.Pattern != `^/api/(?P<subroute>.*)$ > ^widgets$` || !slices.Equal(widgets.Methods, []string{"*"}) {
+		t.Fatalf("unexpected sub-route info %+v", widgets)
+	}
+
+	errStop := errors.New("stop")
+	visited := 0
+	err := m.Walk(func(info RouteInfo) error {
+		visited++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || visited != 1 {
+		t.Fatalf("expected Walk to stop at the first error, got %v after %d", err, visited)
+	}
+}
+
+// TestOnRouteChange verifies events are reported for registrations, including
+// through inline muxes and sub-Router mounts, and for removals.
+func TestOnRouteChange(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	m := New()
+	var events []RouteEvent
+	m.OnRouteChange(func(ev RouteEvent) {
+		events = append(events, ev)
+	})
+
+	m.Get(`^/users$`, h)
+	m.Group(func(r Router) {
+		r.Post(`^/users$`, h)
+	})
+	m.Route(`^/api/(?P<subroute>.*)$`, func(r Router) {
+		r.Get(`^widgets$`, h)
+	})
+	m.Remove(http.MethodGet, `^/users$`)
+	m.Remove(http.MethodGet, `^/users$`)
+
+	want := []RouteEvent{
+		{RouteAdded, "GET", `^/users$`},
+		{RouteAdded, "POST", `^/users$`},
+		{RouteAdded, "*", `^/api/(?P<subroute>.*)$`},
+		{RouteRemoved, "GET", `^/users$`},
+	}
+	if !slices.Equal(events, want) {
+		t.Fatalf("expected events %+v, got %+v", want, events)
+	}
+}
@@ -0,0 +1,164 @@
+package regexrouter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+)
+
+// condition is a request predicate that routes registered through When must
+// satisfy, in addition to their pattern. Conditions are compared by pointer:
+// each When call creates a distinct one, so the same pattern can be registered
+// under several conditions without the registrations merging.
+type condition struct {
+	match func(*http.Request) bool
+
+	// Set for a condition added by Host, whose failure can make the request
+	// misdirected; see WithMisdirectedStatus.
+	host bool
+
+	// The condition of the mux When was called on, which must hold too.
+	parent *condition
+}
+
+func (c *condition) ok(r *http.Request) bool {
+	for ; c != nil; c = c.parent {
+		if !c.match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostFailed reports whether a Host condition among c and its parents
+// rejects r.
+func (c *condition) hostFailed(r *http.Request) bool {
+	for ; c != nil; c = c.parent {
+		if c.host && !c.match(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// When returns an inline Router, like With, whose routes match only requests
+// for which match returns true, in addition to matching their pattern. When
+// match returns false the route is skipped as if its pattern had not matched,
+// so later routes, including ones with the same pattern, are tried. When can
+// be nested; every enclosing condition must hold. match runs during routing,
+// before any middleware added by Use.
+func (mx *Mux) When(match func(r *http.Request) bool) Router {
+	return &Mux{
+		parent: mx,
+		inline: true,
+		cond:   &condition{match: match, parent: mx.condition()},
+	}
+}
+
+// WhenContext returns an inline Router whose routes match only when the
+// request context holds want under key. Matching happens before the
+// middleware added by Use runs, so the value must be set by middleware around
+// the whole router (see Wrap). For example, to serve a route to one tenant
+// only:
+//
+//	m.WhenContext(tenantKey{}, "acme").Get(`^/reports$`, acmeReports)
+func (mx *Mux) WhenContext(key, want any) Router {
+	return mx.When(func(r *http.Request) bool {
+		return r.Context().Value(key) == want
+	})
+}
+
+// ContentLength returns an inline Router whose routes match only requests
+// whose declared Content-Length is between min and max inclusive, for example
+// to send small uploads and large ones to different handlers. A request of
+// unknown length (r.ContentLength of -1) matches only if min is negative.
+func (mx *Mux) ContentLength(min, max int64) Router {
+	return mx.When(func(r *http.Request) bool {
+		return r.ContentLength >= min && r.ContentLength <= max
+	})
+}
+
+// Proto returns an inline Router whose routes match only requests made with
+// HTTP version major.minor, as reported by r.ProtoMajor and r.ProtoMinor, for
+// example to serve HTTP/1.1 and HTTP/2 clients differently.
+func (mx *Mux) Proto(major, minor int) Router {
+	return mx.When(func(r *http.Request) bool {
+		return r.ProtoMajor == major && r.ProtoMinor == minor
+	})
+}
+
+// ClientCert returns an inline Router whose routes match only requests over
+// TLS presenting a client certificate whose subject (in the form of
+// pkix.Name.String, e.g. "CN=billing,O=Example") matches the regular
+// expression subjectPattern. Requests without a client certificate fall
+// through. The certificate is not verified here; configure the server's
+// tls.Config.ClientAuth for that. ClientCert panics on an invalid pattern.
+func (mx *Mux) ClientCert(subjectPattern string) Router {
+	re, err := regexp.Compile(subjectPattern)
+	if err != nil {
+		panic(fmt.Sprintf("regexrouter: invalid client certificate subject pattern %q: %v", subjectPattern, err))
+	}
+	return mx.When(func(r *http.Request) bool {
+		return r.TLS != nil && len(r.TLS.PeerCertificates) > 0 &&
+			re.MatchString(r.TLS.PeerCertificates[0].Subject.String())
+	})
+}
+
+// Cookie returns an inline Router whose routes match only requests carrying
+// a cookie called name whose value matches the regular expression
+// valuePattern, for example to route sessions by bucket:
+//
+//	m.Cookie("bucket", `^b$`).Get(`^/checkout$`, checkoutB)
+//
+// Requests without the cookie fall through. Cookie panics on an invalid
+// pattern.
+func (mx *Mux) Cookie(name, valuePattern string) Router {
+	re, err := regexp.Compile(valuePattern)
+	if err != nil {
+		panic(fmt.Sprintf("regexrouter: invalid cookie value pattern %q: %v", valuePattern, err))
+	}
+	return mx.When(func(r *http.Request) bool {
+		c, err := r.Cookie(name)
+		return err == nil && re.MatchString(c.Value)
+	})
+}
+
+// Host returns an inline Router whose routes match only requests whose Host,
+// without any port, matches the regular expression hostPattern, as in
+// m.Host(`^api\.example\.com$`). Other requests fall through to later routes;
+// see WithMisdirectedStatus to answer them with 421 Misdirected Request when
+// nothing else matches. Host panics on an invalid pattern.
+func (mx *Mux) Host(hostPattern string) Router {
+	re, err := regexp.Compile(hostPattern)
+	if err != nil {
+		panic(fmt.Sprintf("regexrouter: invalid host pattern %q: %v", hostPattern, err))
+	}
+	return &Mux{
+		parent: mx,
+		inline: true,
+		cond: &condition{
+			match: func(r *http.Request) bool {
+				host, _, err := net.SplitHostPort(r.Host)
+				if err != nil {
+					host = r.Host
+				}
+				return re.MatchString(host)
+			},
+			host:   true,
+			parent: mx.condition(),
+		},
+	}
+}
+
+// condition returns the condition routes registered on mx must satisfy: its
+// own, or for an inline mux without one, that of the mux it is inlined into.
+func (mx *Mux) condition() *condition {
+	if mx.cond != nil {
+		return mx.cond
+	}
+	if mx.parent != nil && mx.inline {
+		return mx.parent.condition()
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package regexrouter
+
+import (
+	"cmp"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tenantKey struct{}
+
+// TestWhenContext verifies a WhenContext route matches only for the expected
+// context value and otherwise falls through to a later route with the same
+// pattern.
+func TestWhenContext(t *testing.T) {
+	m := New()
+	m.WhenContext(tenantKey{}, "acme").Get(`^/reports$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("acme reports"))
+	})
+	m.Get(`^/reports$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("reports"))
+	})
+
+	// The tenant must be in the context before matching, so it is set by
+	// middleware around the whole router rather than by Use.
+	h := m.Wrap(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), tenantKey{}, r.Header.Get("X-Tenant"))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+
+	for tenant, want := range map[string]string{
+		"acme":   "acme reports",
+		"globex": "reports",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/reports", nil)
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Body.String() != want {
+			t.Fatalf("tenant %q: expected %q, got %q", tenant, want, rec.Body.String())
+		}
+	}
+}
+
+// TestProto verifies an HTTP/2-only route falls through to the general one
+// for HTTP/1.x requests.
+func TestProto(t *testing.T) {
+	m := New()
+	m.Proto(2, 0).Get(`^/stream$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("h2 stream"))
+	})
+	m.Get(`^/stream$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("polling"))
+	})
+
+	for _, tc := range []struct {
+		major, minor int
+		want         string
+	}{
+		{2, 0, "h2 stream"},
+		{1, 1, "polling"},
+		{1, 0, "polling"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+		req.ProtoMajor, req.ProtoMinor = tc.major, tc.minor
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		if rec.Body.String() != tc.want {
+			t.Fatalf("HTTP/%d.%d: expected %q, got %q", tc.major, tc.minor, tc.want, rec.Body.String())
+		}
+	}
+}
+
+// TestCookie verifies requests are split between variants of the same pattern
+// by a cookie's value, and that requests without the cookie fall through.
+func TestCookie(t *testing.T) {
+	m := New()
+	m.Cookie("bucket", `^b$`).Get(`^/checkout$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("checkout b"))
+	})
+	m.Get(`^/checkout$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("checkout a"))
+	})
+
+	for cookie, want := range map[string]string{
+		"b":  "checkout b",
+		"bb": "checkout a",
+		"a":  "checkout a",
+		"":   "checkout a",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/checkout", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "bucket", Value: cookie})
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		if rec.Body.String() != want {
+			t.Fatalf("cookie %q: expected %q, got %q", cookie, want, rec.Body.String())
+		}
+	}
+}
+
+// TestHostMisdirected verifies a host-scoped route serves its host, and that
+// its path under another host gets 421 when configured and 404 otherwise.
+func TestHostMisdirected(t *testing.T) {
+	for _, code := range []int{http.StatusMisdirectedRequest, 0} {
+		var opts []Option
+		if code != 0 {
+			opts = append(opts, WithMisdirectedStatus(code))
+		}
+		m := New(opts...)
+		m.Host(`^api\.example\.com$`).Get(`^/users$`, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("users"))
+		})
+
+		for _, tc := range []struct {
+			host, path string
+			wantCode   int
+		}{
+			{"api.example.com:8443", "/users", http.StatusOK},
+			{"www.example.com", "/users", cmp.Or(code, http.StatusNotFound)},
+			{"www.example.com", "/other", http.StatusNotFound},
+		} {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Host = tc.host
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status %d configured, %s%s: expected %d, got %d", code, tc.host, tc.path, tc.wantCode, rec.Code)
+			}
+		}
+	}
+}
+
+// TestContentLength verifies requests are split between variants of the same
+// pattern by declared Content-Length.
+func TestContentLength(t *testing.T) {
+	m := New()
+	m.ContentLength(0, 16).Post(`^/upload$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("inline"))
+	})
+	m.ContentLength(17, math.MaxInt64).Post(`^/upload$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("chunked"))
+	})
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	runTestCases(t, ts, []testCase{
+		{
+			name:           "small body",
+			path:           "/upload",
+			method:         http.MethodPost,
+			body:           strings.NewReader("tiny"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "inline",
+		}, {
+			name:           "large body",
+			path:           "/upload",
+			method:         http.MethodPost,
+			body:           strings.NewReader(strings.Repeat("x", 1024)),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "chunked",
+		},
+	})
+}
+
+// TestClientCert verifies a ClientCert route matches only a TLS client whose
+// certificate subject matches, others falling through.
+func TestClientCert(t *testing.T) {
+	m := New()
+	m.ClientCert(`^CN=billing$`).Get(`^/whoami$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("billing"))
+	})
+	m.Get(`^/whoami$`, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("anonymous"))
+	})
+
+	ts := httptest.NewUnstartedServer(m)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(certs ...tls.Certificate) string {
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		resp, err := (&http.Client{Transport: transport}).Get(ts.URL + "/whoami")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := get(clientCert(t, "billing")); got != "billing" {
+		t.Fatalf("expected the billing client to match, got %q", got)
+	}
+	if got := get(clientCert(t, "billing-admin")); got != "anonymous" {
+		t.Fatalf("expected a non-matching subject to fall through, got %q", got)
+	}
+	if got := get(); got != "anonymous" {
+		t.Fatalf("expected a client without a certificate to fall through, got %q", got)
+	}
+}
+
+// clientCert returns a self-signed client certificate for common name cn.
+func clientCert(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
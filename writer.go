@@ -0,0 +1,18 @@
+package regexrouter
+
+import "net/http"
+
+// headResponseWriter discards the response body for HEAD requests served by
+// an all-methods handler, keeping the headers and status it sets.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}